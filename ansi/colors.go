@@ -0,0 +1,41 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package ansi
+
+import "fmt"
+
+// colorNames are the 16 basic colors in the order of their SGR codes.
+var colorNames = [16]string{
+	"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+	"bright-black", "bright-red", "bright-green", "bright-yellow",
+	"bright-blue", "bright-magenta", "bright-cyan", "bright-white",
+}
+
+// cubeLevels are the component values of the 6x6x6 color cube of the
+// 256-color palette.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// paletteColor returns the color of the 256-color palette, the first 16 of
+// which are the basic colors.
+func paletteColor(index int) string {
+	switch {
+	case index < 16:
+		return colorNames[index]
+	case index < 232:
+		index -= 16
+		return hexColor(cubeLevels[index/36], cubeLevels[index/6%6], cubeLevels[index%6])
+	default:
+		gray := 8 + (index-232)*10
+		return hexColor(gray, gray, gray)
+	}
+}
+
+func hexColor(r, g, b int) string {
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
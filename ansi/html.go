@@ -0,0 +1,93 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package ansi
+
+import (
+	"html"
+	"io"
+	"strings"
+)
+
+// WriteHTML writes the spans as escaped text, wrapping styled spans into
+// span elements. Basic colors and attributes become classes, e.g.
+// "ansi-red ansi-bg-blue ansi-bold", and other colors inline styles. Colors
+// of inverse spans are swapped, and they get the "ansi-inverse" class for
+// the default colors to be swapped by the stylesheet.
+func WriteHTML(w io.Writer, spans []Span) error {
+	for _, span := range spans {
+		text := html.EscapeString(span.Text)
+		if span.Style == (Style{}) {
+			if _, err := io.WriteString(w, text); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		classes, styles := htmlAttributes(&span.Style)
+		var b strings.Builder
+		b.WriteString(`<span`)
+		if len(classes) > 0 {
+			b.WriteString(` class="` + strings.Join(classes, " ") + `"`)
+		}
+
+		if len(styles) > 0 {
+			b.WriteString(` style="` + strings.Join(styles, ";") + `"`)
+		}
+
+		b.WriteString(">" + text + "</span>")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// htmlAttributes returns classes and inline styles of the style. Colors are
+// either names of basic colors or generated by the parser, so neither needs
+// escaping.
+func htmlAttributes(style *Style) ([]string, []string) {
+	var classes, styles []string
+	foreground, background := style.Foreground, style.Background
+	if style.Inverse {
+		foreground, background = background, foreground
+	}
+
+	for _, color := range []struct{ value, class, property string }{
+		{foreground, "ansi-", "color"},
+		{background, "ansi-bg-", "background-color"},
+	} {
+		if strings.HasPrefix(color.value, "#") {
+			styles = append(styles, color.property+":"+color.value)
+		} else if color.value != "" {
+			classes = append(classes, color.class+color.value)
+		}
+	}
+
+	for _, attribute := range []struct {
+		set   bool
+		class string
+	}{
+		{style.Bold, "ansi-bold"},
+		{style.Faint, "ansi-faint"},
+		{style.Italic, "ansi-italic"},
+		{style.Underline, "ansi-underline"},
+		{style.Blink, "ansi-blink"},
+		{style.Inverse, "ansi-inverse"},
+		{style.Hidden, "ansi-hidden"},
+		{style.Strikethrough, "ansi-strikethrough"},
+	} {
+		if attribute.set {
+			classes = append(classes, attribute.class)
+		}
+	}
+
+	return classes, styles
+}
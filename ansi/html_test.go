@@ -0,0 +1,72 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package ansi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteHTML(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		spans []Span
+		want  string
+	}{
+		{"plain text is escaped", []Span{plain(`<b>&"'`)}, "&lt;b&gt;&amp;&#34;&#39;"},
+		{
+			"styled text is escaped",
+			[]Span{styled("<script>alert(1)</script>", Style{Italic: true})},
+			`<span class="ansi-italic">&lt;script&gt;alert(1)&lt;/script&gt;</span>`,
+		},
+		{
+			"classes",
+			[]Span{styled("x", Style{Foreground: "red", Background: "bright-blue", Bold: true, Underline: true})},
+			`<span class="ansi-red ansi-bg-bright-blue ansi-bold ansi-underline">x</span>`,
+		},
+		{
+			"inline styles",
+			[]Span{styled("x", Style{Foreground: "#ff0000", Background: "#010203"})},
+			`<span style="color:#ff0000;background-color:#010203">x</span>`,
+		},
+		{
+			"inverse",
+			[]Span{styled("x", Style{Background: "green", Foreground: "#ff0000", Inverse: true})},
+			`<span class="ansi-green ansi-inverse" style="background-color:#ff0000">x</span>`,
+		},
+		{
+			"spans in order",
+			[]Span{plain("a "), styled("b", Style{Strikethrough: true}), plain(" c")},
+			`a <span class="ansi-strikethrough">b</span> c`,
+		},
+	} {
+		var b strings.Builder
+		if err := WriteHTML(&b, test.spans); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := b.String(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParsedOutputIsEscaped(t *testing.T) {
+	var p Parser
+	var b strings.Builder
+	err := WriteHTML(&b, p.Parse("\x1b[1m<img src=x onerror=alert(1)>\x1b[0m & \x1b]0;<title>\x07done"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<span class="ansi-bold">&lt;img src=x onerror=alert(1)&gt;</span> &amp; done`
+	if got := b.String(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,330 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package ansi
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	esc = 0x1b
+	bel = 0x07
+
+	// 8-bit forms of CSI and OSC, which appear in UTF-8 text as the
+	// corresponding code points.
+	csi8 = 0x9b
+	osc8 = 0x9d
+)
+
+// Style is the SGR state of the terminal. Colors are names of the 16 basic
+// colors, e.g. "red" or "bright-red", or "#rrggbb"; empty means the default.
+type Style struct {
+	Foreground    string `json:"fg,omitempty"`
+	Background    string `json:"bg,omitempty"`
+	Bold          bool   `json:"bold,omitempty"`
+	Faint         bool   `json:"faint,omitempty"`
+	Italic        bool   `json:"italic,omitempty"`
+	Underline     bool   `json:"underline,omitempty"`
+	Blink         bool   `json:"blink,omitempty"`
+	Inverse       bool   `json:"inverse,omitempty"`
+	Hidden        bool   `json:"hidden,omitempty"`
+	Strikethrough bool   `json:"strikethrough,omitempty"`
+}
+
+// Span is a piece of text printed in one style.
+type Span struct {
+	Text string `json:"text"`
+	Style
+}
+
+// Parser splits lines of terminal output into styled spans. The style is
+// kept between lines, as a terminal does, so lines of an output are parsed
+// in order by one parser.
+type Parser struct {
+	style Style
+}
+
+// Parse returns the text of the line as spans. SGR sequences change the
+// style; other escape sequences, e.g. cursor movements, and control
+// characters are removed. A carriage return discards the text before it and
+// a backspace the character before it, as they would be overwritten.
+func (p *Parser) Parse(line string) []Span {
+	spans := []Span{}
+	var text strings.Builder
+	emit := func() {
+		if text.Len() == 0 {
+			return
+		}
+
+		if n := len(spans); n > 0 && spans[n-1].Style == p.style {
+			spans[n-1].Text += text.String()
+		} else {
+			spans = append(spans, Span{Text: text.String(), Style: p.style})
+		}
+
+		text.Reset()
+	}
+
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		switch {
+		case r == esc:
+			i = p.skipEscape(line, i+size, emit)
+		case r == csi8:
+			i = p.skipControlSequence(line, i+size, emit)
+		case r == osc8:
+			i = skipString(line, i+size)
+		case r == '\r':
+			i += size
+			if i < len(line) {
+				spans = spans[:0]
+				text.Reset()
+			}
+		case r == '\b':
+			i += size
+			current := text.String()
+			if _, last := utf8.DecodeLastRuneInString(current); last > 0 {
+				text.Reset()
+				text.WriteString(current[:len(current)-last])
+			}
+		case r == '\t' || (r >= 0x20 && r != 0x7f && (r < 0x80 || r > 0x9f)):
+			text.WriteString(line[i : i+size])
+			i += size
+		default:
+			i += size
+		}
+	}
+
+	emit()
+	return spans
+}
+
+// skipEscape skips the escape sequence whose ESC precedes i, applying it if
+// it is an SGR sequence, and returns the index following it.
+func (p *Parser) skipEscape(line string, i int, emit func()) int {
+	if i >= len(line) {
+		return i
+	}
+
+	switch line[i] {
+	case '[':
+		return p.skipControlSequence(line, i+1, emit)
+	case ']', 'P', 'X', '^', '_':
+		return skipString(line, i+1)
+	}
+
+	// nF sequences, e.g. character set designations, have intermediate
+	// bytes before the final one; other sequences have a single byte.
+	for i < len(line) && line[i] >= 0x20 && line[i] <= 0x2f {
+		i++
+	}
+
+	if i < len(line) && line[i] >= 0x30 && line[i] <= 0x7e {
+		i++
+	}
+
+	return i
+}
+
+// skipControlSequence skips the control sequence whose introducer precedes
+// i, applying it if it is an SGR sequence, and returns the index following
+// it. A malformed sequence is skipped up to the offending byte.
+func (p *Parser) skipControlSequence(line string, i int, emit func()) int {
+	start := i
+	for i < len(line) && line[i] >= 0x30 && line[i] <= 0x3f {
+		i++
+	}
+
+	params := line[start:i]
+	intermediate := i
+	for i < len(line) && line[i] >= 0x20 && line[i] <= 0x2f {
+		i++
+	}
+
+	if i >= len(line) || line[i] < 0x40 || line[i] > 0x7e {
+		return i
+	}
+
+	if line[i] == 'm' && intermediate == i && !strings.ContainsAny(params, "<=>?") {
+		emit()
+		p.applySGR(params)
+	}
+
+	return i + 1
+}
+
+// skipString skips a control string, e.g. an OSC sequence, which ends with
+// ST or BEL, and returns the index following it.
+func skipString(line string, i int) int {
+	for i < len(line) {
+		switch {
+		case line[i] == bel:
+			return i + 1
+		case line[i] == esc && i+1 < len(line) && line[i+1] == '\\':
+			return i + 2
+		case strings.HasPrefix(line[i:], "\u009c"):
+			return i + len("\u009c")
+		}
+
+		i++
+	}
+
+	return i
+}
+
+func (p *Parser) applySGR(params string) {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		parts := strings.Split(codes[i], ":")
+		code, ok := parseParam(parts[0])
+		if !ok {
+			continue
+		}
+
+		s := &p.style
+		switch {
+		case code == 0:
+			*s = Style{}
+		case code == 1:
+			s.Bold = true
+		case code == 2:
+			s.Faint = true
+		case code == 3:
+			s.Italic = true
+		case code == 4 || code == 21:
+			s.Underline = len(parts) < 2 || parts[1] != "0"
+		case code == 5 || code == 6:
+			s.Blink = true
+		case code == 7:
+			s.Inverse = true
+		case code == 8:
+			s.Hidden = true
+		case code == 9:
+			s.Strikethrough = true
+		case code == 22:
+			s.Bold, s.Faint = false, false
+		case code == 23:
+			s.Italic = false
+		case code == 24:
+			s.Underline = false
+		case code == 25:
+			s.Blink = false
+		case code == 27:
+			s.Inverse = false
+		case code == 28:
+			s.Hidden = false
+		case code == 29:
+			s.Strikethrough = false
+		case code >= 30 && code <= 37:
+			s.Foreground = colorNames[code-30]
+		case code == 38 || code == 48 || code == 58:
+			var color string
+			if len(parts) > 1 {
+				color = extendedColor(parts[1:], true)
+			} else {
+				var used int
+				color, used = extendedColorParams(codes[i+1:])
+				i += used
+			}
+
+			if code == 38 {
+				s.Foreground = color
+			} else if code == 48 {
+				s.Background = color
+			}
+		case code == 39:
+			s.Foreground = ""
+		case code >= 40 && code <= 47:
+			s.Background = colorNames[code-40]
+		case code == 49:
+			s.Background = ""
+		case code >= 90 && code <= 97:
+			s.Foreground = colorNames[code-90+8]
+		case code >= 100 && code <= 107:
+			s.Background = colorNames[code-100+8]
+		}
+	}
+}
+
+// extendedColorParams reads a 256-color or RGB color from the parameters
+// following 38 or 48 and returns it with the number of parameters used.
+func extendedColorParams(params []string) (string, int) {
+	if len(params) == 0 {
+		return "", 0
+	}
+
+	switch params[0] {
+	case "5":
+		if len(params) < 2 {
+			return "", len(params)
+		}
+
+		return extendedColor(params[:2], false), 2
+	case "2":
+		if len(params) < 4 {
+			return "", len(params)
+		}
+
+		return extendedColor(params[:4], false), 4
+	}
+
+	return "", 1
+}
+
+// extendedColor converts "5;n" or "2;r;g;b" to a color. The colon form of
+// RGB colors may have a color space ID before the components.
+func extendedColor(params []string, colons bool) string {
+	switch params[0] {
+	case "5":
+		if len(params) < 2 {
+			return ""
+		}
+
+		index, ok := parseParam(params[1])
+		if !ok || index > 255 {
+			return ""
+		}
+
+		return paletteColor(index)
+	case "2":
+		components := params[1:]
+		if colons && len(components) > 3 {
+			components = components[1:]
+		}
+
+		if len(components) < 3 {
+			return ""
+		}
+
+		var rgb [3]int
+		for i := range rgb {
+			value, ok := parseParam(components[i])
+			if !ok || value > 255 {
+				return ""
+			}
+
+			rgb[i] = value
+		}
+
+		return hexColor(rgb[0], rgb[1], rgb[2])
+	}
+
+	return ""
+}
+
+func parseParam(param string) (int, bool) {
+	if param == "" {
+		return 0, true
+	}
+
+	value, err := strconv.Atoi(param)
+	return value, err == nil && value >= 0
+}
@@ -0,0 +1,142 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package ansi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func plain(text string) Span {
+	return Span{Text: text}
+}
+
+func styled(text string, style Style) Span {
+	return Span{Text: text, Style: style}
+}
+
+type parseTest struct {
+	name  string
+	line  string
+	spans []Span
+}
+
+func checkParse(t *testing.T, tests []parseTest) {
+	for _, test := range tests {
+		var p Parser
+		spans := p.Parse(test.line)
+		want := test.spans
+		if want == nil {
+			want = []Span{}
+		}
+
+		if !reflect.DeepEqual(spans, want) {
+			t.Errorf("%s: %q is parsed as %+v, want %+v", test.name, test.line, spans, want)
+		}
+	}
+}
+
+func TestParseSGR(t *testing.T) {
+	checkParse(
+		t, []parseTest{
+			{"plain text", "hello", []Span{plain("hello")}},
+			{"reset", "\x1b[31mred\x1b[0m plain", []Span{styled("red", Style{Foreground: "red"}), plain(" plain")}},
+			{"empty reset", "\x1b[1;32mA\x1b[mB", []Span{styled("A", Style{Foreground: "green", Bold: true}), plain("B")}},
+			{"bright colors", "\x1b[91;104mX", []Span{styled("X", Style{Foreground: "bright-red", Background: "bright-blue"})}},
+			{"default colors", "\x1b[31;42m\x1b[39;49mX", []Span{plain("X")}},
+			{"attributes off", "\x1b[1;2;3;5;7;8;9m\x1b[22;23;25;27;28;29mX", []Span{plain("X")}},
+			{"underline off by colon", "\x1b[4:0mX", []Span{plain("X")}},
+			{"underline style", "\x1b[4:3mX", []Span{styled("X", Style{Underline: true})}},
+			{"same style merged", "\x1b[31ma\x1b[31mb", []Span{styled("ab", Style{Foreground: "red"})}},
+			{"256 colors", "\x1b[38;5;196;48;5;21mX", []Span{styled("X", Style{Foreground: "#ff0000", Background: "#0000ff"})}},
+			{"256 colors with colons", "\x1b[38:5:9mX", []Span{styled("X", Style{Foreground: "bright-red"})}},
+			{"grayscale", "\x1b[38;5;244mX", []Span{styled("X", Style{Foreground: "#808080"})}},
+			{"rgb", "\x1b[48;2;1;2;3mX", []Span{styled("X", Style{Background: "#010203"})}},
+			{"rgb with colons", "\x1b[38:2:10:20:30mX", []Span{styled("X", Style{Foreground: "#0a141e"})}},
+			{"rgb with color space", "\x1b[38:2::10:20:30mX", []Span{styled("X", Style{Foreground: "#0a141e"})}},
+			{"code after extended color", "\x1b[38;2;1;2;3;1mX", []Span{styled("X", Style{Foreground: "#010203", Bold: true})}},
+			{"underline color ignored", "\x1b[58;5;1;3mX", []Span{styled("X", Style{Italic: true})}},
+			{"palette index out of range", "\x1b[38;5;256;1mX", []Span{styled("X", Style{Bold: true})}},
+			{"rgb component out of range", "\x1b[38;2;1;2;300mX", []Span{plain("X")}},
+			{"truncated 256 color", "\x1b[38;5mX", []Span{plain("X")}},
+			{"truncated rgb", "\x1b[38;2;1;2mX", []Span{plain("X")}},
+			{"truncated rgb with colons", "\x1b[38:2:1:2mX", []Span{plain("X")}},
+			{"unknown color kind", "\x1b[38;7;1mX", []Span{styled("X", Style{Bold: true})}},
+			{"invalid parameter", "\x1b[x31mX", []Span{plain("31mX")}},
+		},
+	)
+}
+
+func TestParseTruncatedSequences(t *testing.T) {
+	checkParse(
+		t, []parseTest{
+			{"lone escape", "a\x1b", []Span{plain("a")}},
+			{"unterminated CSI", "a\x1b[31", []Span{plain("a")}},
+			{"unterminated SGR is not applied", "a\x1b[31;", []Span{plain("a")}},
+			{"CSI with intermediate only", "a\x1b[1 ", []Span{plain("a")}},
+			{"unterminated OSC", "a\x1b]0;title", []Span{plain("a")}},
+			{"unterminated 8-bit CSI", "a\u009b3", []Span{plain("a")}},
+			{"unterminated nF sequence", "a\x1b(", []Span{plain("a")}},
+			{"malformed CSI", "a\x1b[3\x01mb", []Span{plain("amb")}},
+		},
+	)
+}
+
+func TestParseStripsCursorMovement(t *testing.T) {
+	checkParse(
+		t, []parseTest{
+			{"clear and home", "\x1b[2J\x1b[Hstart", []Span{plain("start")}},
+			{"cursor position", "a\x1b[10;5Hb\x1b[3Ac\x1b[2Dd", []Span{plain("abcd")}},
+			{"erase line", "\x1b[31mtext\x1b[K", []Span{styled("text", Style{Foreground: "red"})}},
+			{"private modes", "\x1b[?25lhidden cursor\x1b[?25h", []Span{plain("hidden cursor")}},
+			{"private SGR", "\x1b[>4;2mX", []Span{plain("X")}},
+			{"SGR with intermediate", "\x1b[1 mX", []Span{plain("X")}},
+			{"save and restore", "\x1b7x\x1b8y", []Span{plain("xy")}},
+			{"character set", "\x1b(Bx\x1b)0y", []Span{plain("xy")}},
+		},
+	)
+}
+
+func TestParseIntroducersAndControls(t *testing.T) {
+	checkParse(
+		t, []parseTest{
+			{"8-bit CSI", "\u009b31mred", []Span{styled("red", Style{Foreground: "red"})}},
+			{"8-bit OSC ended by 8-bit ST", "\u009d0;title\u009ctext", []Span{plain("text")}},
+			{"OSC ended by BEL", "\x1b]0;title\x07text", []Span{plain("text")}},
+			{"hyperlink", "\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\", []Span{plain("link")}},
+			{"DCS", "\x1bPq#0\x1b\\ok", []Span{plain("ok")}},
+			{"APC, PM and SOS", "\x1b_a\x07\x1b^b\x07\x1bXc\x07ok", []Span{plain("ok")}},
+			{"carriage return", "progress 10%\rprogress 100%", []Span{plain("progress 100%")}},
+			{"trailing carriage return", "line\r", []Span{plain("line")}},
+			{"carriage return keeps style", "\x1b[31mred\rover", []Span{styled("over", Style{Foreground: "red"})}},
+			{"carriage return drops spans", "a\x1b[1mb\rc", []Span{styled("c", Style{Bold: true})}},
+			{"backspace", "ab\bc", []Span{plain("ac")}},
+			{"backspace over multibyte", "ї\bx", []Span{plain("x")}},
+			{"backspace at start", "\bx", []Span{plain("x")}},
+			{"backspace within span only", "a\x1b[1m\bb", []Span{plain("a"), styled("b", Style{Bold: true})}},
+			{"control characters", "a\tb\x07\x7f\x00c", []Span{plain("a\tbc")}},
+			{"C1 controls", "\u0085x\u009fy", []Span{plain("xy")}},
+			{"unicode", "Привіт, світе", []Span{plain("Привіт, світе")}},
+		},
+	)
+}
+
+func TestParserKeepsStyleBetweenLines(t *testing.T) {
+	var p Parser
+	p.Parse("\x1b[1;34mfirst")
+	spans := p.Parse("second\x1b[0m third")
+	want := []Span{styled("second", Style{Foreground: "blue", Bold: true}), plain(" third")}
+	if !reflect.DeepEqual(spans, want) {
+		t.Errorf("second line is parsed as %+v, want %+v", spans, want)
+	}
+
+	if spans = p.Parse("fourth"); !reflect.DeepEqual(spans, []Span{plain("fourth")}) {
+		t.Errorf("line after reset is parsed as %+v", spans)
+	}
+}
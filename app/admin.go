@@ -0,0 +1,387 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 500
+
+	// forcedExitCode is set to jobs finished by an operator without an
+	// exit code.
+	forcedExitCode = -1
+)
+
+var jobStatuses = []jobs.JobStatus{
+	jobs.JobStatusAccepted,
+	jobs.JobStatusRejected,
+	jobs.JobStatusQueued,
+	jobs.JobStatusRunning,
+	jobs.JobStatusFinished,
+}
+
+// adminJob shows operators the client which created the job.
+type adminJob struct {
+	*jobs.Job
+
+	Client string `json:"client"`
+}
+
+type FinishJobForm struct {
+	ExitCode *int `json:"exit_code"`
+}
+
+type MaintenanceForm struct {
+	Enabled *bool  `json:"enabled" binding:"required"`
+	Message string `json:"message"`
+}
+
+func (a *Application) addAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/admin", a.adminAuthMiddleware)
+	admin.GET("/jobs", a.listJobsHandler)
+	admin.POST("/jobs/:id/finish", a.finishJobHandler)
+	admin.POST("/jobs/:id/requeue", a.requeueJobHandler)
+	admin.DELETE("/jobs/:id/outputs", a.purgeJobOutputsHandler)
+	admin.GET("/queues", a.getAdminQueuesHandler)
+	admin.GET("/consumers", a.getConsumersHandler)
+	admin.GET("/maintenance", a.getMaintenanceHandler)
+	admin.PUT("/maintenance", a.setMaintenanceHandler)
+}
+
+// adminAuthMiddleware accepts requests with the admin token as a bearer
+// token. The admin API looks missing while the token is not set.
+func (a *Application) adminAuthMiddleware(c *gin.Context) {
+	config := a.settings.Get().Admin
+	if !config.Enabled() {
+		a.sendJsonError(c, http.StatusNotFound, errors.New("admin API is disabled"))
+		c.Abort()
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.Token)) != 1 {
+		c.Header("WWW-Authenticate", "Bearer")
+		a.sendJsonError(c, http.StatusUnauthorized, errors.New("admin token is missing or invalid"))
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+func (a *Application) listJobsHandler(c *gin.Context) {
+	filter, err := parseJobFilter(c)
+	if err != nil {
+		a.sendJsonError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		a.sendJsonError(c, http.StatusBadRequest, errors.New("offset must be a non-negative integer"))
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAdminPageSize)))
+	if err != nil || limit <= 0 || limit > maxAdminPageSize {
+		a.sendJsonError(
+			c,
+			http.StatusBadRequest,
+			fmt.Errorf("limit must be an integer from 1 to %d", maxAdminPageSize),
+		)
+		return
+	}
+
+	found, total, err := a.replicaJobService.ListJobs(filter, offset, limit)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	items := make([]adminJob, len(found))
+	for i := range found {
+		items[i] = adminJob{Job: &found[i], Client: found[i].Client}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"total": total, "offset": offset, "limit": limit, "jobs": items})
+}
+
+func parseJobFilter(c *gin.Context) (jobs.JobFilter, error) {
+	filter := jobs.JobFilter{
+		LangVersion: c.Query("lang_version"),
+		Client:      c.Query("client"),
+		BatchID:     c.Query("batch_id"),
+	}
+	if statuses := c.Query("status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			if !isJobStatus(jobs.JobStatus(status)) {
+				return filter, fmt.Errorf("invalid job status '%s'", status)
+			}
+
+			filter.Statuses = append(filter.Statuses, jobs.JobStatus(status))
+		}
+	}
+
+	var err error
+	times := []struct {
+		name  string
+		value **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"updated_before", &filter.UpdatedBefore},
+	}
+	for _, t := range times {
+		*t.value, err = parseTimeQuery(c, t.name)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+func isJobStatus(status jobs.JobStatus) bool {
+	for _, s := range jobStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+
+	return &t, nil
+}
+
+// finishJobHandler finishes a stuck job with the given exit code and sends
+// its callback. Results which arrive later are dropped.
+func (a *Application) finishJobHandler(c *gin.Context) {
+	var form FinishJobForm
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&form); err != nil {
+			a.sendJsonError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	job, ok := a.getAdminJob(c, a.jobService.GetJob)
+	if !ok {
+		return
+	}
+
+	exitCode := forcedExitCode
+	if form.ExitCode != nil {
+		exitCode = *form.ExitCode
+	}
+
+	job.ExitCode = &exitCode
+	job.MarkFinished(time.Now())
+	err := a.jobService.TransitionJob(
+		job,
+		jobs.JobStatusFinished,
+		"finished by an operator",
+		"exit_code",
+		"started_at",
+		"finished_at",
+		"duration_ms",
+	)
+	if err != nil {
+		a.sendTransitionError(c, err)
+		return
+	}
+
+	logging.Infof("Job %s was finished by an operator with exit code %d", job.ID, exitCode)
+	a.enqueueWebhook(job)
+	c.JSON(http.StatusOK, adminJob{Job: job, Client: job.Client})
+}
+
+// requeueJobHandler drops the results of a job which has not started
+// running and publishes it again. A running job can only be finished by an
+// operator.
+func (a *Application) requeueJobHandler(c *gin.Context) {
+	job, ok := a.getAdminJob(c, a.jobService.GetJobWithProject)
+	if !ok {
+		return
+	}
+
+	switch job.Status {
+	case jobs.JobStatusAccepted, jobs.JobStatusQueued, jobs.JobStatusRejected:
+	default:
+		a.sendJsonError(c, http.StatusConflict, fmt.Errorf("job with status %s can not be requeued", job.Status))
+		return
+	}
+
+	if !stringArrayContains(a.settings.Get().BorschVersions, job.LangVersion) {
+		a.sendJsonError(
+			c,
+			http.StatusConflict,
+			fmt.Errorf("language version '%s' is not available", job.LangVersion),
+		)
+		return
+	}
+
+	job.Attempts++
+	err := a.jobService.TransitionJob(job, jobs.JobStatusAccepted, "requeued by an operator", "attempts")
+	if err != nil {
+		a.sendTransitionError(c, err)
+		return
+	}
+
+	err = a.jobService.ResetJob(job)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.publishJob(job)
+	logging.Infof("Job %s was requeued by an operator with status %s", job.ID, job.Status)
+	c.JSON(http.StatusOK, adminJob{Job: job, Client: job.Client})
+}
+
+func (a *Application) purgeJobOutputsHandler(c *gin.Context) {
+	job, ok := a.getAdminJob(c, a.jobService.GetJob)
+	if !ok {
+		return
+	}
+
+	deleted, err := a.jobService.DeleteJobOutputs(job.ID)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// sendTransitionError answers with 409 if the job can not change its
+// status.
+func (a *Application) sendTransitionError(c *gin.Context, err error) {
+	var transitionErr *jobs.TransitionError
+	if errors.As(err, &transitionErr) {
+		a.sendJsonError(c, http.StatusConflict, err)
+	} else {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+	}
+}
+
+func (a *Application) getAdminJob(c *gin.Context, get func(id string) (*jobs.Job, error)) (*jobs.Job, bool) {
+	job, err := get(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.sendJsonError(c, http.StatusNotFound, errors.New("job not found"))
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
+		return nil, false
+	}
+
+	return job, true
+}
+
+// getAdminQueuesHandler inspects the job queues right away, unlike the
+// public queue endpoint, which is cached.
+func (a *Application) getAdminQueuesHandler(c *gin.Context) {
+	stats, err := a.amqpJobService.GetQueueStats()
+	if err != nil {
+		a.sendJsonError(c, http.StatusBadGateway, err)
+		return
+	}
+
+	versions := map[string][]string{}
+	for _, queue := range jobQueues(a.settings.Get()) {
+		versions[queue.Name] = queue.Versions
+	}
+
+	queues := make([]queueInfo, len(stats))
+	for i := range stats {
+		queues[i] = queueInfo{
+			Name:      stats[i].Name,
+			Versions:  versions[stats[i].Name],
+			Messages:  &stats[i].Messages,
+			Consumers: &stats[i].Consumers,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"queues": queues})
+}
+
+// getConsumersHandler shows the result consumer of this instance and the
+// number of workers consuming each job queue, which is null if the broker
+// could not be asked.
+func (a *Application) getConsumersHandler(c *gin.Context) {
+	var workers []gin.H
+	stats, err := a.amqpJobService.GetQueueStats()
+	if err != nil {
+		logging.Warningf("Failed to get queue stats: %v", err)
+	} else {
+		workers = make([]gin.H, len(stats))
+		for i, queue := range stats {
+			workers[i] = gin.H{"queue": queue.Name, "consumers": queue.Consumers}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": a.amqpJobService.GetConsumerStatus(), "workers": workers})
+}
+
+func (a *Application) getMaintenanceHandler(c *gin.Context) {
+	mode, err := a.jobService.GetMaintenanceMode()
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, mode)
+}
+
+func (a *Application) setMaintenanceHandler(c *gin.Context) {
+	var form MaintenanceForm
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		a.sendJsonError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	mode := &jobs.MaintenanceMode{Enabled: *form.Enabled, Message: form.Message}
+	err = a.jobService.SetMaintenanceMode(mode)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.maintenance.set(mode)
+	logging.Infof("Maintenance mode was set to %t by an operator", mode.Enabled)
+	c.JSON(http.StatusOK, mode)
+}
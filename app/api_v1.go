@@ -17,13 +17,24 @@ import (
 func (a *Application) addV1Routes(r *gin.Engine) {
 	apiV1 := r.Group("/api/v1")
 	apiV1.GET("/lang/versions", a.getLanguageVersionsHandler)
+	apiV1.GET("/queue", a.getQueueHandler)
 
 	jobsRouter := apiV1.Group("/jobs")
-	jobsRouter.GET("/:id", a.getJobHandler)
-	jobsRouter.GET("/:id/output", a.getJobOutputHandler)
-	jobsRouter.POST("/", a.createJobHandler)
+	a.handleNamed(jobsRouter, routeJob, http.MethodGet, "/:id", a.getJobHandler)
+	a.handleNamed(jobsRouter, routeJobOutput, http.MethodGet, "/:id/output", a.getJobOutputHandler)
+	jobsRouter.GET("/:id/output/search", a.searchJobOutputHandler)
+	jobsRouter.GET("/:id/tests", a.getJobTestCasesHandler)
+	jobsRouter.GET("/:id/events", a.getJobEventsHandler)
+	jobsRouter.GET("/:id/webhooks", a.getJobWebhooksHandler)
+	jobsRouter.DELETE("/:id", a.deleteJobHandler)
+	jobsRouter.POST("/:id/retry", a.maintenanceMiddleware, a.rateLimitMiddleware, a.retryJobHandler)
+	jobsRouter.POST("/", a.maintenanceMiddleware, a.idempotencyMiddleware, a.rateLimitMiddleware, a.createJobHandler)
+	jobsRouter.POST("/batch", a.maintenanceMiddleware, a.createJobBatchHandler)
+
+	batchesRouter := apiV1.Group("/batches")
+	a.handleNamed(batchesRouter, routeBatch, http.MethodGet, "/:id", a.getJobBatchHandler)
 }
 
 func (a *Application) getLanguageVersionsHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, a.settings.BorschVersions)
+	c.JSON(http.StatusOK, a.settings.Get().BorschVersions)
 }
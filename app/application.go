@@ -13,12 +13,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
 	rmq "borsch-playground-api/rmq"
 	"borsch-playground-api/settings"
 	"github.com/gin-gonic/gin"
@@ -26,31 +28,62 @@ import (
 )
 
 type Application struct {
-	settings       *settings.Settings
+	settings       *settings.Holder
 	db             *gorm.DB
 	jobService     jobs.JobService
 	amqpJobService rmq.AMQPJobService
+	rateLimiter    *rateLimiter
+	queueSnapshots queueSnapshotCache
+	maintenance    maintenanceCache
+	trustedProxies []*net.IPNet
+	routes         map[string]string
+
+	// replicaJobService reads from read replicas. It serves statistics and
+	// listings, which tolerate data lagging behind.
+	replicaJobService jobs.JobService
+
+	// retryPolicy returns the current retry policy of jobs.
+	retryPolicy func() jobs.RetryPolicy
 }
 
 func NewApp(
-	s *settings.Settings,
+	s *settings.Holder,
 	db *gorm.DB,
 	jobService jobs.JobService,
+	replicaJobService jobs.JobService,
 	amqpJobService rmq.AMQPJobService,
+	retryPolicy func() jobs.RetryPolicy,
 ) (*Application, error) {
-	gin.SetMode(s.GinMode)
+	gin.SetMode(s.Get().GinMode)
+	trustedProxies, err := settings.ParseTrustedProxies(s.Get().TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
 	app := &Application{
 		settings:       s,
 		db:             db,
 		jobService:     jobService,
 		amqpJobService: amqpJobService,
+		rateLimiter:    newRateLimiter(),
+		trustedProxies: trustedProxies,
+
+		replicaJobService: replicaJobService,
+		retryPolicy:       retryPolicy,
 	}
 	return app, nil
 }
 
 func (a *Application) buildRouter() *gin.Engine {
 	router := gin.Default()
+	err := router.SetTrustedProxies(a.settings.Get().TrustedProxies)
+	if err != nil {
+		logging.Errorf("Failed to set trusted proxies: %v", err)
+	}
+
+	a.routes = map[string]string{}
 	a.addV1Routes(router)
+	a.addAdminRoutes(router)
 	return router
 }
 
@@ -58,6 +91,7 @@ func (a *Application) Execute(addr string) error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	go a.runRetrier(ctx)
 	router := a.buildRouter()
 	server := &http.Server{
 		Addr:    addr,
@@ -73,14 +107,14 @@ func (a *Application) Execute(addr string) error {
 	<-ctx.Done()
 
 	stop()
-	log.Println("shutting down gracefully, press Ctrl+C again to force")
+	logging.Infof("shutting down gracefully, press Ctrl+C again to force")
 
-	ctx, cancel := context.WithTimeout(context.Background(), a.settings.ShutdownTimeoutSec*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), a.settings.Get().ShutdownTimeoutSec*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		return errors.New(fmt.Sprintf("Server forced to shut down: %v", err))
 	}
 
-	log.Println("Server exiting")
+	logging.Infof("Server exiting")
 	return nil
 }
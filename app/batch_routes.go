@@ -0,0 +1,191 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"borsch-playground-api/common"
+	"borsch-playground-api/jobs"
+	rmq "borsch-playground-api/rmq"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	batchStatusPending   = "pending"
+	batchStatusCompleted = "completed"
+)
+
+type batchItemResult struct {
+	Index         int                  `json:"index"`
+	JobID         string               `json:"job_id,omitempty"`
+	DeletionToken string               `json:"deletion_token,omitempty"`
+	Cached        bool                 `json:"cached,omitempty"`
+	Error         string               `json:"error,omitempty"`
+	Links         map[string]jobs.Link `json:"links,omitempty"`
+}
+
+func (a *Application) createJobBatchHandler(c *gin.Context) {
+	var form CreateJobBatchForm
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		a.sendJsonError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(form.Jobs) == 0 {
+		a.sendJsonError(c, http.StatusBadRequest, errors.New("jobs are not provided"))
+		return
+	}
+
+	maxBatchSize := a.settings.Get().MaxBatchSize
+	if len(form.Jobs) > maxBatchSize {
+		a.sendJsonError(
+			c,
+			http.StatusBadRequest,
+			fmt.Errorf("too many jobs in the batch, the maximum is %d", maxBatchSize),
+		)
+		return
+	}
+
+	// Every job of the batch counts against the rate limit.
+	if !a.reserveJobs(c, len(form.Jobs)) {
+		return
+	}
+
+	results := make([]batchItemResult, len(form.Jobs))
+	var validJobs []*jobs.Job
+	for i := range form.Jobs {
+		results[i].Index = i
+		err = a.validateCreateJobForm(&form.Jobs[i])
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		job, deletionToken, err := a.newJob(c, &form.Jobs[i])
+		if err != nil {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.applyCachedResult(&form.Jobs[i], job)
+		a.addJobLinks(c, job)
+		results[i].JobID = job.ID
+		results[i].DeletionToken = deletionToken
+		results[i].Cached = job.Cached
+		results[i].Links = job.Links
+		validJobs = append(validJobs, job)
+	}
+
+	if len(validJobs) == 0 {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{
+				"message":           "none of the jobs is valid",
+				"documentation_url": a.settings.Get().ApiDocumentationUrl,
+				"results":           results,
+			},
+		)
+		return
+	}
+
+	batch := &jobs.JobBatch{Model: common.Model{ID: uuid.New().String()}}
+	err = a.jobService.CreateBatch(batch, validJobs)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(
+		http.StatusCreated,
+		gin.H{
+			"batch_id": batch.ID,
+			"results":  results,
+			"links":    gin.H{"self": jobs.Link{Href: a.urlFor(c, routeBatch, "id", batch.ID)}},
+		},
+	)
+
+	// Jobs finished from the result cache are never published.
+	var queuedJobs []*jobs.Job
+	for _, job := range validJobs {
+		if job.Cached {
+			a.enqueueWebhook(job)
+		} else {
+			queuedJobs = append(queuedJobs, job)
+		}
+	}
+
+	a.publishJobs(queuedJobs)
+}
+
+func (a *Application) getJobBatchHandler(c *gin.Context) {
+	batch, err := a.jobService.GetBatch(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.sendJsonError(c, http.StatusNotFound, errors.New("batch not found"))
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
+		return
+	}
+
+	status := batchStatusCompleted
+	statuses := map[jobs.JobStatus]int{}
+	items := make([]gin.H, len(batch.Jobs))
+	for i := range batch.Jobs {
+		job := &batch.Jobs[i]
+		statuses[job.Status]++
+		if !job.Settled() {
+			status = batchStatusPending
+		}
+
+		a.addJobLinks(c, job)
+		items[i] = gin.H{"id": job.ID, "status": job.Status, "exit_code": job.ExitCode, "links": job.Links}
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"id":         batch.ID,
+			"created_at": batch.CreatedAt,
+			"status":     status,
+			"total":      len(batch.Jobs),
+			"statuses":   statuses,
+			"jobs":       items,
+			"links":      gin.H{"self": jobs.Link{Href: a.urlFor(c, routeBatch, "id", batch.ID)}},
+		},
+	)
+}
+
+// publishJobs pushes the jobs of a batch to the RabbitMQ and updates their
+// statuses.
+func (a *Application) publishJobs(batchJobs []*jobs.Job) {
+	var messages []*rmq.JobMessage
+	var published []*jobs.Job
+	for _, job := range batchJobs {
+		message, err := newJobMessage(job)
+		if err != nil {
+			a.recordPublished(job, err)
+			continue
+		}
+
+		messages = append(messages, message)
+		published = append(published, job)
+	}
+
+	for i, err := range a.amqpJobService.PublishJobs(messages) {
+		a.recordPublished(published[i], err)
+	}
+}
@@ -8,7 +8,32 @@
 
 package app
 
+// CreateJobForm with test cases creates a job of the "test" kind. The job's
+// compare mode is used for test cases which do not set their own.
+//
+// Multi-file projects set Files, keyed by relative paths, and EntryPoint
+// instead of SourceCode.
 type CreateJobForm struct {
-	LangVersion string `json:"lang_version"`
-	SourceCode  string `json:"source_code"`
+	LangVersion string            `json:"lang_version"`
+	SourceCode  string            `json:"source_code"`
+	Files       map[string]string `json:"files"`
+	EntryPoint  string            `json:"entry_point"`
+	Args        []string          `json:"args"`
+	Env         map[string]string `json:"env"`
+	TestCases   []TestCaseForm    `json:"test_cases"`
+	CompareMode string            `json:"compare_mode"`
+	CallbackUrl string            `json:"callback_url"`
+	NoCache     bool              `json:"no_cache"`
+	Priority    *int              `json:"priority"`
+}
+
+type TestCaseForm struct {
+	Stdin            string `json:"stdin"`
+	ExpectedStdout   string `json:"expected_stdout"`
+	ExpectedExitCode *int   `json:"expected_exit_code"`
+	CompareMode      string `json:"compare_mode"`
+}
+
+type CreateJobBatchForm struct {
+	Jobs []CreateJobForm `json:"jobs"`
 }
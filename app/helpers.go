@@ -9,19 +9,24 @@
 package app
 
 import (
-	"log"
 	"net/http"
 
+	"borsch-playground-api/logging"
 	"github.com/gin-gonic/gin"
 )
 
 func (a *Application) sendJsonError(c *gin.Context, status int, err error) {
-	log.Println(err)
+	if status == -1 || status >= http.StatusInternalServerError {
+		logging.Errorf("%v", err)
+	} else {
+		logging.Debugf("%v", err)
+	}
+
 	if status != -1 {
 		c.JSON(
 			status, gin.H{
 				"message":           err.Error(),
-				"documentation_url": a.settings.ApiDocumentationUrl,
+				"documentation_url": a.settings.Get().ApiDocumentationUrl,
 			},
 		)
 	} else {
@@ -29,6 +34,14 @@ func (a *Application) sendJsonError(c *gin.Context, status int, err error) {
 	}
 }
 
+func getOrDefault(val, default_ string) string {
+	if val == "" {
+		return default_
+	}
+
+	return val
+}
+
 func stringArrayContains(array []string, item string) bool {
 	for _, elem := range array {
 		if elem == item {
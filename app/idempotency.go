@@ -0,0 +1,146 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyKeyContentType = "application/json; charset=utf-8"
+
+	// idempotencyKeyLease is how long a key is held by a request which is
+	// still being processed.
+	idempotencyKeyLease = time.Minute
+)
+
+// responseRecorder keeps a copy of the response body.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// idempotencyMiddleware replays the stored response to requests which a
+// client repeats with the same Idempotency-Key header and body. Only
+// successful responses are stored, so failed requests can be retried with
+// the same key. The deletion token is left out of stored responses; it is
+// only given to the first request.
+func (a *Application) idempotencyMiddleware(c *gin.Context) {
+	value := c.GetHeader(idempotencyKeyHeader)
+	if value == "" {
+		c.Next()
+		return
+	}
+
+	if len(value) > maxIdempotencyKeyLength {
+		a.sendJsonError(c, http.StatusBadRequest, errors.New("idempotency key is too long"))
+		c.Abort()
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		a.sendJsonError(c, http.StatusBadRequest, err)
+		c.Abort()
+		return
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	hash := sha256.Sum256(body)
+	key := &jobs.IdempotencyKey{
+		Client:      c.ClientIP(),
+		Key:         value,
+		RequestHash: hex.EncodeToString(hash[:]),
+		ExpiresAt:   time.Now().Add(idempotencyKeyLease),
+	}
+	existing, err := a.jobService.ReserveIdempotencyKey(key)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		c.Abort()
+		return
+	}
+
+	if existing != nil {
+		switch {
+		case existing.RequestHash != key.RequestHash:
+			a.sendJsonError(
+				c,
+				http.StatusUnprocessableEntity,
+				errors.New("idempotency key was already used with a different request body"),
+			)
+		case !existing.Completed():
+			a.sendJsonError(
+				c, http.StatusConflict, errors.New("request with this idempotency key is still in progress"),
+			)
+		default:
+			c.Header(idempotentReplayedHeader, "true")
+			c.Data(existing.StatusCode, idempotencyKeyContentType, []byte(existing.Response))
+		}
+
+		c.Abort()
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	c.Next()
+
+	status := recorder.Status()
+	if status >= 200 && status < 300 {
+		ttl := time.Duration(a.settings.Get().IdempotencyTtlHours) * time.Hour
+		response := replayableResponse(recorder.body.Bytes())
+		err = a.jobService.CompleteIdempotencyKey(key, status, response, time.Now().Add(ttl))
+	} else {
+		err = a.jobService.ReleaseIdempotencyKey(key)
+	}
+
+	if err != nil {
+		logging.Errorf("Failed to store idempotency key: %v", err)
+	}
+}
+
+// replayableResponse returns the response body without its deletion token.
+func replayableResponse(body []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return string(body)
+	}
+
+	delete(fields, "deletion_token")
+	response, err := json.Marshal(fields)
+	if err != nil {
+		return string(body)
+	}
+
+	return string(response)
+}
@@ -0,0 +1,523 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"borsch-playground-api/ansi"
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// outputPageSize is the number of lines read from the database at a time
+// while the output is streamed.
+const outputPageSize = 1000
+
+var (
+	errInvalidOutputFormat = errors.New(
+		"invalid response format, available values are 'json', 'ndjson', 'csv', 'txt', 'html' and 'spans'",
+	)
+	errOutputNotAcceptable = errors.New(
+		"none of the accepted media types is available, use application/json, " +
+			"application/x-ndjson, text/csv or text/plain",
+	)
+)
+
+// outputEncoder writes the rows of the output in one of the formats.
+type outputEncoder interface {
+	open() error
+	writeRow(row *jobs.JobOutputRow) error
+	close() error
+}
+
+type outputFormat struct {
+	name        string
+	contentType string
+	extension   string
+
+	// mediaTypes are matched against the Accept header; formats without
+	// them are only available by the format query parameter.
+	mediaTypes []string
+	newEncoder func(w io.Writer, job *jobs.Job) outputEncoder
+}
+
+// outputFormats are listed in the order of preference for wildcard media
+// types, so that text/* is answered with plain text.
+var outputFormats = []*outputFormat{
+	{
+		name:        "json",
+		contentType: "application/json; charset=utf-8",
+		extension:   "json",
+		mediaTypes:  []string{"application/json"},
+		newEncoder: func(w io.Writer, job *jobs.Job) outputEncoder {
+			return &jsonOutputEncoder{w: w, status: job.Status}
+		},
+	},
+	{
+		name:        "txt",
+		contentType: "text/plain; charset=utf-8",
+		extension:   "txt",
+		mediaTypes:  []string{"text/plain"},
+		newEncoder: func(w io.Writer, job *jobs.Job) outputEncoder {
+			return &textOutputEncoder{w: w}
+		},
+	},
+	{
+		name:        "ndjson",
+		contentType: "application/x-ndjson; charset=utf-8",
+		extension:   "ndjson",
+		mediaTypes:  []string{"application/x-ndjson", "application/ndjson"},
+		newEncoder: func(w io.Writer, job *jobs.Job) outputEncoder {
+			return &ndjsonOutputEncoder{w: w}
+		},
+	},
+	{
+		name:        "csv",
+		contentType: "text/csv; charset=utf-8",
+		extension:   "csv",
+		mediaTypes:  []string{"text/csv"},
+		newEncoder: func(w io.Writer, job *jobs.Job) outputEncoder {
+			return &csvOutputEncoder{w: csv.NewWriter(w)}
+		},
+	},
+	{
+		name:        "html",
+		contentType: "text/html; charset=utf-8",
+		extension:   "html",
+		newEncoder: func(w io.Writer, job *jobs.Job) outputEncoder {
+			return &htmlOutputEncoder{w: w}
+		},
+	},
+	{
+		name:        "spans",
+		contentType: "application/json; charset=utf-8",
+		extension:   "json",
+		newEncoder: func(w io.Writer, job *jobs.Job) outputEncoder {
+			return &spansOutputEncoder{jsonOutputEncoder: jsonOutputEncoder{w: w, status: job.Status}}
+		},
+	},
+}
+
+func (f *outputFormat) accepts(mediaType string) bool {
+	if mediaType == "*/*" {
+		return len(f.mediaTypes) > 0
+	}
+
+	for _, t := range f.mediaTypes {
+		if t == mediaType || (strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(t, mediaType[:len(mediaType)-1])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiateOutputFormat picks the format from the format query parameter or,
+// without it, from the Accept header; JSON is the default.
+func negotiateOutputFormat(c *gin.Context) (*outputFormat, int, error) {
+	if name, ok := c.GetQuery("format"); ok {
+		for _, format := range outputFormats {
+			if format.name == strings.ToLower(name) {
+				return format, 0, nil
+			}
+		}
+
+		return nil, http.StatusBadRequest, errInvalidOutputFormat
+	}
+
+	accept := c.GetHeader("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return outputFormats[0], 0, nil
+	}
+
+	for _, mediaType := range parseQualityList(accept) {
+		for _, format := range outputFormats {
+			if format.accepts(mediaType) {
+				return format, 0, nil
+			}
+		}
+	}
+
+	return nil, http.StatusNotAcceptable, errOutputNotAcceptable
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(c *gin.Context) bool {
+	for _, encoding := range parseQualityList(c.GetHeader("Accept-Encoding")) {
+		if encoding == "gzip" || encoding == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseQualityList returns the values of an Accept-like header without
+// their parameters, the most preferred first; values with zero quality are
+// left out.
+func parseQualityList(header string) []string {
+	type item struct {
+		value   string
+		quality float64
+	}
+
+	var items []item
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		if value == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			pair := strings.SplitN(param, "=", 2)
+			if len(pair) == 2 && strings.TrimSpace(pair[0]) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(pair[1]), 64)
+				if err == nil {
+					quality = parsed
+				}
+			}
+		}
+
+		if quality > 0 {
+			items = append(items, item{value: value, quality: quality})
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].quality > items[j].quality })
+	values := make([]string, len(items))
+	for i := range items {
+		values[i] = items[i].value
+	}
+
+	return values
+}
+
+// streamJobOutput writes the output of the job from offset, limit lines or
+// all of them if the limit is negative, reading it page by page. The first
+// page is read before the response starts, so that a failing database still
+// gets an error response; a failure after that leaves the response
+// incomplete.
+func (a *Application) streamJobOutput(
+	c *gin.Context, job *jobs.Job, format *outputFormat, offset, limit int, download bool,
+) {
+	if offset < 0 {
+		offset = 0
+	}
+
+	// Archived outputs are read whole anyway, so they are not paged. The
+	// output of a cached job is the output of the job it reuses.
+	source := job
+	if job.CachedFromID != nil {
+		var err error
+		source, err = a.jobService.GetOutputJob(job.ID)
+		if err != nil {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	pageLimit := func() int {
+		if source.OutputArchived() || (limit >= 0 && limit < outputPageSize) {
+			return limit
+		}
+
+		return outputPageSize
+	}
+
+	var page []jobs.JobOutputRow
+	if limit != 0 {
+		var err error
+		page, err = a.jobService.GetJobOutputs(job.ID, offset, pageLimit())
+		if err != nil {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	c.Header("Content-Type", format.contentType)
+	c.Header("Vary", "Accept, Accept-Encoding")
+	if download {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, job.ID, format.extension))
+	}
+
+	var out io.Writer = c.Writer
+	var gz *gzip.Writer
+	if acceptsGzip(c) {
+		c.Header("Content-Encoding", "gzip")
+		gz = gzip.NewWriter(c.Writer)
+		out = gz
+	}
+
+	c.Status(http.StatusOK)
+	buffer := bufio.NewWriter(out)
+	flush := func() error {
+		if err := buffer.Flush(); err != nil {
+			return err
+		}
+
+		if gz != nil {
+			if err := gz.Flush(); err != nil {
+				return err
+			}
+		}
+
+		c.Writer.Flush()
+		return nil
+	}
+
+	encoder := format.newEncoder(buffer, job)
+	err := encoder.open()
+	for err == nil && len(page) > 0 {
+		for i := range page {
+			if err = encoder.writeRow(&page[i]); err != nil {
+				break
+			}
+		}
+
+		if err != nil {
+			break
+		}
+
+		requested := pageLimit()
+		offset += len(page)
+		if limit > 0 {
+			limit -= len(page)
+		}
+
+		if err = flush(); err != nil || len(page) < requested || requested < 0 || limit == 0 {
+			break
+		}
+
+		page, err = a.jobService.GetJobOutputs(job.ID, offset, pageLimit())
+	}
+
+	if err == nil {
+		err = encoder.close()
+	}
+
+	if err == nil {
+		err = buffer.Flush()
+	}
+
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+
+	if err != nil {
+		logging.Errorf("Failed to stream output of job %s: %v", job.ID, err)
+	}
+}
+
+// jsonOutputEncoder writes the rows as the rows array of an object which
+// holds the status of the job as well.
+type jsonOutputEncoder struct {
+	w      io.Writer
+	status jobs.JobStatus
+	rows   int
+}
+
+func (e *jsonOutputEncoder) open() error {
+	status, err := json.Marshal(e.status)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(e.w, `{"status":%s,"rows":[`, status)
+	return err
+}
+
+func (e *jsonOutputEncoder) writeRow(row *jobs.JobOutputRow) error {
+	return e.writeValue(row)
+}
+
+func (e *jsonOutputEncoder) writeValue(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if e.rows > 0 {
+		if _, err = io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+
+	e.rows++
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonOutputEncoder) close() error {
+	_, err := io.WriteString(e.w, "]}")
+	return err
+}
+
+// ndjsonOutputEncoder writes every row as a JSON object on its own line.
+type ndjsonOutputEncoder struct {
+	w io.Writer
+}
+
+func (e *ndjsonOutputEncoder) open() error {
+	return nil
+}
+
+func (e *ndjsonOutputEncoder) writeRow(row *jobs.JobOutputRow) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+func (e *ndjsonOutputEncoder) close() error {
+	return nil
+}
+
+// csvFormulaPrefixes are the first characters which make spreadsheets
+// evaluate a cell as a formula.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvOutputEncoder writes the rows as CSV records with a header. Texts
+// which spreadsheets would evaluate as formulas are prefixed with a quote.
+type csvOutputEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvOutputEncoder) open() error {
+	return e.write([]string{"id", "created_at", "text"})
+}
+
+func (e *csvOutputEncoder) writeRow(row *jobs.JobOutputRow) error {
+	return e.write(
+		[]string{
+			strconv.FormatUint(uint64(row.ID), 10),
+			row.CreatedAt.UTC().Format(time.RFC3339Nano),
+			escapeCsvFormula(row.Text),
+		},
+	)
+}
+
+func escapeCsvFormula(text string) string {
+	if text != "" && strings.IndexByte(csvFormulaPrefixes, text[0]) >= 0 {
+		return "'" + text
+	}
+
+	return text
+}
+
+func (e *csvOutputEncoder) write(record []string) error {
+	if err := e.w.Write(record); err != nil {
+		return err
+	}
+
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvOutputEncoder) close() error {
+	return nil
+}
+
+// textOutputEncoder writes the text of the rows separated by new lines.
+type textOutputEncoder struct {
+	w    io.Writer
+	rows int
+}
+
+func (e *textOutputEncoder) open() error {
+	return nil
+}
+
+func (e *textOutputEncoder) writeRow(row *jobs.JobOutputRow) error {
+	if e.rows > 0 {
+		if _, err := io.WriteString(e.w, "\n"); err != nil {
+			return err
+		}
+	}
+
+	e.rows++
+	_, err := io.WriteString(e.w, row.Text)
+	return err
+}
+
+func (e *textOutputEncoder) close() error {
+	return nil
+}
+
+// htmlOutputEncoder writes the lines into a pre element, turning ANSI
+// escape sequences into styled spans.
+type htmlOutputEncoder struct {
+	w      io.Writer
+	parser ansi.Parser
+	rows   int
+}
+
+func (e *htmlOutputEncoder) open() error {
+	_, err := io.WriteString(e.w, `<pre class="ansi-output">`)
+	return err
+}
+
+func (e *htmlOutputEncoder) writeRow(row *jobs.JobOutputRow) error {
+	if e.rows > 0 {
+		if _, err := io.WriteString(e.w, "\n"); err != nil {
+			return err
+		}
+	}
+
+	e.rows++
+	return ansi.WriteHTML(e.w, e.parser.Parse(row.Text))
+}
+
+func (e *htmlOutputEncoder) close() error {
+	_, err := io.WriteString(e.w, "</pre>")
+	return err
+}
+
+// outputSpansRow is a row of the output whose text is parsed into spans.
+type outputSpansRow struct {
+	ID        uint        `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	JobID     string      `json:"job_id"`
+	Spans     []ansi.Span `json:"spans"`
+}
+
+// spansOutputEncoder writes the rows as JSON with their text parsed into
+// styled spans.
+type spansOutputEncoder struct {
+	jsonOutputEncoder
+	parser ansi.Parser
+}
+
+func (e *spansOutputEncoder) writeRow(row *jobs.JobOutputRow) error {
+	return e.writeValue(
+		&outputSpansRow{
+			ID:        row.ID,
+			CreatedAt: row.CreatedAt,
+			JobID:     row.JobID,
+			Spans:     e.parser.Parse(row.Text),
+		},
+	)
+}
@@ -0,0 +1,53 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"borsch-playground-api/jobs"
+)
+
+func TestCsvOutputEscapesFormulas(t *testing.T) {
+	var buffer bytes.Buffer
+	encoder := &csvOutputEncoder{w: csv.NewWriter(&buffer)}
+	texts := map[string]string{
+		"=HYPERLINK(\"http://x\")": "'=HYPERLINK(\"http://x\")",
+		"+1+2":                     "'+1+2",
+		"-5":                       "'-5",
+		"@SUM(A1)":                 "'@SUM(A1)",
+		"\tindented":               "'\tindented",
+		"\rcarriage":               "'\rcarriage",
+		"plain = text":             "plain = text",
+		"":                         "",
+		"'quoted":                  "'quoted",
+	}
+
+	for text, want := range texts {
+		buffer.Reset()
+		row := &jobs.JobOutputRow{Text: text}
+		row.ID = 1
+		row.CreatedAt = time.Date(2022, 11, 20, 12, 0, 0, 0, time.UTC)
+		if err := encoder.writeRow(row); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := csv.NewReader(&buffer).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := records[0][2]; got != want {
+			t.Errorf("text %q is written as %q, want %q", text, got, want)
+		}
+	}
+}
@@ -11,19 +11,30 @@ package app
 import (
 	"encoding/base64"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	"borsch-playground-api/common"
 	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
 	rmq "borsch-playground-api/rmq"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+const (
+	deletionTokenHeader = "X-Deletion-Token"
+	maxTestCases        = 100
+
+	maxSearchQueryLength = 1000
+	maxSearchContext     = 20
+	defaultSearchLimit   = 100
+	maxSearchLimit       = 1000
+)
+
 func (a *Application) getJobHandler(c *gin.Context) {
 	job, err := a.jobService.GetJob(c.Param("id"))
 	if err != nil {
@@ -36,10 +47,79 @@ func (a *Application) getJobHandler(c *gin.Context) {
 		return
 	}
 
-	job.OutputUrl = job.GetOutputUrl(c)
+	err = a.jobService.LoadSource(job)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if job.Kind == jobs.JobKindTest {
+		testCases, err := a.jobService.GetTestCases(job.ID)
+		if err != nil {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		job.TestSummary = jobs.Summarize(testCases)
+	}
+
+	if job.Status == jobs.JobStatusQueued {
+		job.Queue, err = a.estimateQueue(job)
+		if err != nil {
+			logging.Warningf("Failed to estimate queue of job %s: %v", job.ID, err)
+		}
+	}
+
+	a.addJobLinks(c, job)
 	c.JSON(http.StatusOK, job)
 }
 
+func (a *Application) getJobTestCasesHandler(c *gin.Context) {
+	job, err := a.jobService.GetJob(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.sendJsonError(c, http.StatusNotFound, errors.New("job not found"))
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
+		return
+	}
+
+	testCases, err := a.jobService.GetTestCases(job.ID)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{"status": job.Status, "summary": jobs.Summarize(testCases), "test_cases": testCases},
+	)
+}
+
+// getJobEventsHandler returns the status history of the job.
+func (a *Application) getJobEventsHandler(c *gin.Context) {
+	job, err := a.jobService.GetJob(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.sendJsonError(c, http.StatusNotFound, errors.New("job not found"))
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
+		return
+	}
+
+	events, err := a.jobService.GetJobEvents(job.ID)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": job.Status, "events": events})
+}
+
 func (a *Application) getJobOutputHandler(c *gin.Context) {
 	jobId := c.Param("id")
 	offsetParam := c.DefaultQuery("offset", "-1")
@@ -56,6 +136,21 @@ func (a *Application) getJobOutputHandler(c *gin.Context) {
 		return
 	}
 
+	download := false
+	if downloadParam, ok := c.GetQuery("download"); ok {
+		download, err = strconv.ParseBool(downloadParam)
+		if err != nil {
+			a.sendJsonError(c, http.StatusBadRequest, errors.New("download is invalid boolean value"))
+			return
+		}
+	}
+
+	format, status, err := negotiateOutputFormat(c)
+	if err != nil {
+		a.sendJsonError(c, status, err)
+		return
+	}
+
 	job, err := a.jobService.GetJob(jobId)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -67,34 +162,69 @@ func (a *Application) getJobOutputHandler(c *gin.Context) {
 		return
 	}
 
-	outputs, err := a.jobService.GetJobOutputs(jobId, offset, limit)
+	a.streamJobOutput(c, job, format, offset, limit, download)
+}
+
+func (a *Application) searchJobOutputHandler(c *gin.Context) {
+	search, err := parseOutputSearch(c)
 	if err != nil {
-		a.sendJsonError(c, http.StatusInternalServerError, err)
+		a.sendJsonError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := a.jobService.GetJob(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.sendJsonError(c, http.StatusNotFound, errors.New("job not found"))
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
 		return
 	}
 
-	formatParam := c.DefaultQuery("format", "json")
-	switch strings.ToLower(formatParam) {
-	case "json":
-		c.JSON(http.StatusOK, gin.H{"status": job.Status, "rows": outputs})
-	case "txt":
-		outputLen := len(outputs)
-		outputString := ""
-		for i, output := range outputs {
-			outputString += output.Text
-			if i < outputLen-1 {
-				outputString += "\n"
-			}
+	result, err := a.jobService.SearchOutput(job.ID, search)
+	if err != nil {
+		if errors.Is(err, jobs.ErrInvalidSearch) {
+			a.sendJsonError(c, http.StatusBadRequest, err)
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
 		}
 
-		c.String(http.StatusOK, outputString)
-	default:
-		a.sendJsonError(
-			c,
-			http.StatusBadRequest,
-			errors.New("invalid response format, available values are 'json' an 'txt'"),
-		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": job.Status, "matches": result.Matches, "truncated": result.Truncated})
+}
+
+func parseOutputSearch(c *gin.Context) (*jobs.OutputSearch, error) {
+	search := &jobs.OutputSearch{Query: c.Query("q")}
+	if search.Query == "" || len(search.Query) > maxSearchQueryLength {
+		return nil, fmt.Errorf("q must be from 1 to %d bytes long", maxSearchQueryLength)
+	}
+
+	var err error
+	search.Regex, err = strconv.ParseBool(c.DefaultQuery("regex", "false"))
+	if err != nil {
+		return nil, errors.New("regex is invalid boolean value")
+	}
+
+	search.CaseSensitive, err = strconv.ParseBool(c.DefaultQuery("case_sensitive", "false"))
+	if err != nil {
+		return nil, errors.New("case_sensitive is invalid boolean value")
 	}
+
+	search.Context, err = strconv.Atoi(c.DefaultQuery("context", "0"))
+	if err != nil || search.Context < 0 || search.Context > maxSearchContext {
+		return nil, fmt.Errorf("context must be an integer from 0 to %d", maxSearchContext)
+	}
+
+	search.Limit, err = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSearchLimit)))
+	if err != nil || search.Limit <= 0 || search.Limit > maxSearchLimit {
+		return nil, fmt.Errorf("limit must be an integer from 1 to %d", maxSearchLimit)
+	}
+
+	return search, nil
 }
 
 func (a *Application) createJobHandler(c *gin.Context) {
@@ -105,21 +235,103 @@ func (a *Application) createJobHandler(c *gin.Context) {
 		return
 	}
 
-	if form.LangVersion == "" {
-		a.sendJsonError(c, http.StatusBadRequest, errors.New("language version is not provided"))
+	err = a.validateCreateJobForm(&form)
+	if err != nil {
+		a.sendJsonError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	if !stringArrayContains(a.settings.BorschVersions, form.LangVersion) {
-		a.sendJsonError(c, http.StatusBadRequest, errors.New("language version does not exist"))
+	job, deletionToken, err := a.newJob(c, &form)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	if len(form.SourceCode) == 0 {
-		a.sendJsonError(c, http.StatusBadRequest, errors.New("source code is not provided"))
+	a.applyCachedResult(&form, job)
+	err = a.jobService.CreateJob(job)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
 		return
 	}
 
+	a.addJobLinks(c, job)
+	c.JSON(
+		http.StatusCreated,
+		gin.H{
+			"job_id":         job.ID,
+			"output_url":     job.OutputUrl,
+			"deletion_token": deletionToken,
+			"cached":         job.Cached,
+			"links":          job.Links,
+		},
+	)
+	if job.Cached {
+		a.enqueueWebhook(job)
+	} else {
+		a.publishJob(job)
+	}
+}
+
+func (a *Application) validateCreateJobForm(form *CreateJobForm) error {
+	if form.LangVersion == "" {
+		return errors.New("language version is not provided")
+	}
+
+	if !stringArrayContains(a.settings.Get().BorschVersions, form.LangVersion) {
+		return errors.New("language version does not exist")
+	}
+
+	if len(form.SourceCode) == 0 && len(form.Files) == 0 {
+		return errors.New("source code is not provided")
+	}
+
+	err := validateProject(form)
+	if err != nil {
+		return err
+	}
+
+	if form.Priority != nil && *form.Priority < 0 {
+		return errors.New("priority must not be negative")
+	}
+
+	err = a.validateCallbackUrl(form.CallbackUrl)
+	if err != nil {
+		return err
+	}
+
+	if len(form.TestCases) > maxTestCases {
+		return fmt.Errorf("too many test cases, the maximum is %d", maxTestCases)
+	}
+
+	for i, testCase := range form.TestCases {
+		err := jobs.ValidateCompareMode(testCaseCompareMode(form, &testCase), testCase.ExpectedStdout)
+		if err != nil {
+			return fmt.Errorf("test case %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// jobPriority returns the priority of the client, or the one requested in
+// the form if it is lower.
+func (a *Application) jobPriority(client string, form *CreateJobForm) int {
+	priority := a.settings.Get().Priorities.Of(client)
+	if form.Priority != nil && *form.Priority < priority {
+		return *form.Priority
+	}
+
+	return priority
+}
+
+func testCaseCompareMode(form *CreateJobForm, testCase *TestCaseForm) jobs.CompareMode {
+	mode := getOrDefault(testCase.CompareMode, getOrDefault(form.CompareMode, string(jobs.CompareModeExact)))
+	return jobs.CompareMode(mode)
+}
+
+// newJob builds a job from the validated form and returns it with its
+// deletion token.
+func (a *Application) newJob(c *gin.Context, form *CreateJobForm) (*jobs.Job, string, error) {
 	job := &jobs.Job{
 		Model: common.Model{
 			ID: uuid.New().String(),
@@ -128,35 +340,126 @@ func (a *Application) createJobHandler(c *gin.Context) {
 		Outputs:       []jobs.JobOutputRow{},
 		ExitCode:      nil,
 		Status:        jobs.JobStatusAccepted,
+		Client:        c.ClientIP(),
+		Kind:          jobs.JobKindRun,
+		LangVersion:   form.LangVersion,
+		CallbackUrl:   form.CallbackUrl,
+		Priority:      a.jobPriority(c.ClientIP(), form),
+
+		// The attempt is counted before the job is published, so that
+		// results of a fast worker match the stored attempt.
+		Attempts: 1,
 	}
+	addProject(job, form)
 
-	err = a.jobService.CreateJob(job)
+	if len(form.TestCases) > 0 {
+		job.Kind = jobs.JobKindTest
+		for i, testCase := range form.TestCases {
+			job.TestCases = append(
+				job.TestCases, jobs.JobTestCase{
+					Index:            i,
+					Stdin:            testCase.Stdin,
+					ExpectedStdout:   testCase.ExpectedStdout,
+					ExpectedExitCode: testCase.ExpectedExitCode,
+					CompareMode:      testCaseCompareMode(form, &testCase),
+				},
+			)
+		}
+	}
+
+	deletionToken, err := job.NewDeletionToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return job, deletionToken, nil
+}
+
+func (a *Application) deleteJobHandler(c *gin.Context) {
+	token := c.GetHeader(deletionTokenHeader)
+	if token == "" {
+		a.sendJsonError(c, http.StatusUnauthorized, errors.New("deletion token is not provided"))
+		return
+	}
+
+	job, err := a.jobService.GetJob(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.sendJsonError(c, http.StatusNotFound, errors.New("job not found"))
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
+		return
+	}
+
+	if !job.CheckDeletionToken(token) {
+		a.sendJsonError(c, http.StatusForbidden, errors.New("deletion token is invalid"))
+		return
+	}
+
+	err = a.jobService.DeleteJob(job.ID)
 	if err != nil {
 		a.sendJsonError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"job_id": job.ID, "output_url": job.GetOutputUrl(c)})
-	a.publishJob(&form, job)
+	c.Status(http.StatusNoContent)
 }
 
-// publishJob pushes the job to the RabbitMQ and update its status.
-func (a *Application) publishJob(form *CreateJobForm, job *jobs.Job) {
-	jobMessage := rmq.JobMessage{
-		ID:            job.ID,
-		LangVersion:   form.LangVersion,
-		SourceCodeB64: job.SourceCodeB64,
+func newJobMessage(job *jobs.Job) (*rmq.JobMessage, error) {
+	message := &rmq.JobMessage{
+		ID:          job.ID,
+		Kind:        string(job.Kind),
+		LangVersion: job.LangVersion,
+		Priority:    uint8(job.Priority),
+		Attempt:     job.Attempts,
 	}
-	err := a.amqpJobService.PublishJob(&jobMessage)
+	err := setMessagePayload(message, job)
 	if err != nil {
-		log.Printf("Failed to publish job: %v", err)
-		job.Status = jobs.JobStatusRejected
-	} else {
-		job.Status = jobs.JobStatusQueued
+		return nil, err
+	}
+
+	for _, testCase := range job.TestCases {
+		message.TestCases = append(
+			message.TestCases, rmq.TestCaseMessage{
+				Index:    testCase.Index,
+				StdinB64: base64.StdEncoding.EncodeToString([]byte(testCase.Stdin)),
+			},
+		)
+	}
+
+	return message, nil
+}
+
+// publishJob pushes the job to the RabbitMQ and updates its status.
+func (a *Application) publishJob(job *jobs.Job) {
+	message, err := newJobMessage(job)
+	if err == nil {
+		err = a.amqpJobService.PublishJob(message)
 	}
 
-	err = a.jobService.UpdateJob(job)
+	a.recordPublished(job, err)
+}
+
+// recordPublished moves the job to queued or, if publishing failed, to
+// rejected, so that it is retried later. The attempt is counted when the
+// job is created, retried or requeued. A job which has already started
+// running is left as it is.
+func (a *Application) recordPublished(job *jobs.Job, err error) {
 	if err != nil {
-		log.Printf("Failed to update job: %v", err)
+		logging.Errorf("Failed to publish job: %v", err)
+		job.ScheduleRetry(err, a.retryPolicy(), time.Now())
+		err = a.jobService.TransitionJob(
+			job, jobs.JobStatusRejected, "publishing failed", "last_error", "next_retry_at",
+		)
+	} else {
+		job.NextRetryAt = nil
+		err = a.jobService.TransitionJob(job, jobs.JobStatusQueued, "published", "next_retry_at")
+	}
+
+	var transitionErr *jobs.TransitionError
+	if err != nil && !errors.As(err, &transitionErr) {
+		logging.Errorf("Failed to update job: %v", err)
 	}
 }
@@ -0,0 +1,146 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"borsch-playground-api/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+// Names of routes which links are built for.
+const (
+	routeJob       = "job"
+	routeJobOutput = "job-output"
+	routeBatch     = "batch"
+)
+
+// handleNamed registers the route like group.Handle and keeps its path
+// under the name, so that links to it are built by urlFor.
+func (a *Application) handleNamed(
+	group *gin.RouterGroup, name, method, relativePath string, handlers ...gin.HandlerFunc,
+) {
+	group.Handle(method, relativePath, handlers...)
+	a.routes[name] = path.Join(group.BasePath(), relativePath)
+}
+
+// urlFor returns the absolute URL of the named route with its parameters
+// given as name and value pairs, e.g. "id", job.ID.
+func (a *Application) urlFor(c *gin.Context, name string, params ...string) string {
+	route, ok := a.routes[name]
+	if !ok {
+		panic("unknown route " + name)
+	}
+
+	values := map[string]string{}
+	for i := 0; i+1 < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = url.PathEscape(values[segment[1:]])
+		}
+	}
+
+	base := a.baseUrl(c)
+	return base.Scheme + "://" + base.Host + strings.TrimSuffix(base.Path, "/") + strings.Join(segments, "/")
+}
+
+// baseUrl returns the URL the API is reached at: the public URL from the
+// settings or, without it, the scheme and host of the request, which
+// trusted proxies may override by X-Forwarded-Proto and X-Forwarded-Host.
+func (a *Application) baseUrl(c *gin.Context) *url.URL {
+	if publicUrl := a.settings.Get().PublicUrl; publicUrl != "" {
+		// The URL is validated with the settings.
+		if u, err := url.Parse(publicUrl); err == nil {
+			return u
+		}
+	}
+
+	u := &url.URL{Scheme: "http", Host: c.Request.Host}
+	if c.Request.TLS != nil {
+		u.Scheme = "https"
+	}
+
+	if !a.fromTrustedProxy(c) {
+		return u
+	}
+
+	switch proto := strings.ToLower(forwardedValue(c, "X-Forwarded-Proto")); proto {
+	case "http", "https":
+		u.Scheme = proto
+	}
+
+	if host := forwardedValue(c, "X-Forwarded-Host"); isValidHost(host) {
+		u.Host = host
+	}
+
+	return u
+}
+
+func (a *Application) fromTrustedProxy(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range a.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedValue returns the last value of the header, which the trusted
+// proxy has set. Proxies which append to the header keep the values the
+// client sent in front of it, so they must not be used.
+func forwardedValue(c *gin.Context, header string) string {
+	values := strings.Split(c.GetHeader(header), ",")
+	return strings.TrimSpace(values[len(values)-1])
+}
+
+func isValidHost(host string) bool {
+	if host == "" {
+		return false
+	}
+
+	u, err := url.Parse("//" + host)
+	return err == nil && u.Host == host && u.User == nil
+}
+
+// addJobLinks sets links to the job and its output. The cancel link, which
+// deletes the job with its deletion token, is only given while the job may
+// still run, including rejected jobs waiting for a retry.
+func (a *Application) addJobLinks(c *gin.Context, job *jobs.Job) {
+	output := a.urlFor(c, routeJobOutput, "id", job.ID)
+	job.OutputUrl = output
+	job.Links = map[string]jobs.Link{
+		"self":   {Href: a.urlFor(c, routeJob, "id", job.ID)},
+		"output": {Href: output},
+		"stream": {Href: output + "?format=ndjson"},
+	}
+
+	if !job.Settled() {
+		job.Links["cancel"] = jobs.Link{Href: job.Links["self"].Href, Method: http.MethodDelete}
+	}
+}
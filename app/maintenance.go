@@ -0,0 +1,78 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceModeTtl is how long other instances may keep accepting jobs
+// after maintenance mode is switched on.
+const maintenanceModeTtl = 5 * time.Second
+
+const defaultMaintenanceMessage = "the service is under maintenance, try again later"
+
+type maintenanceCache struct {
+	mu        sync.Mutex
+	mode      *jobs.MaintenanceMode
+	fetchedAt time.Time
+}
+
+func (m *maintenanceCache) set(mode *jobs.MaintenanceMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mode = mode
+	m.fetchedAt = time.Now()
+}
+
+func (a *Application) getMaintenanceMode() *jobs.MaintenanceMode {
+	a.maintenance.mu.Lock()
+	defer a.maintenance.mu.Unlock()
+
+	if a.maintenance.mode != nil && time.Since(a.maintenance.fetchedAt) < maintenanceModeTtl {
+		return a.maintenance.mode
+	}
+
+	mode, err := a.jobService.GetMaintenanceMode()
+	if err != nil {
+		// Jobs are accepted while the mode is unknown, creating them
+		// would likely fail anyway.
+		logging.Warningf("Failed to get maintenance mode: %v", err)
+		return &jobs.MaintenanceMode{}
+	}
+
+	a.maintenance.mode = mode
+	a.maintenance.fetchedAt = time.Now()
+	return mode
+}
+
+// maintenanceMiddleware rejects new jobs while maintenance mode is on.
+func (a *Application) maintenanceMiddleware(c *gin.Context) {
+	mode := a.getMaintenanceMode()
+	if !mode.Enabled {
+		c.Next()
+		return
+	}
+
+	// Not sent with sendJsonError, which logs server errors.
+	c.AbortWithStatusJSON(
+		http.StatusServiceUnavailable,
+		gin.H{
+			"message":           getOrDefault(mode.Message, defaultMaintenanceMessage),
+			"documentation_url": a.settings.Get().ApiDocumentationUrl,
+		},
+	)
+}
@@ -0,0 +1,169 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+
+	"borsch-playground-api/jobs"
+	rmq "borsch-playground-api/rmq"
+)
+
+const (
+	maxProjectFiles = 100
+	maxProjectSize  = 1 << 20
+	maxArguments    = 64
+	maxEnvVars      = 64
+
+	defaultEntryPoint = "main.b"
+)
+
+// validateProject checks files, arguments and environment variables of the
+// form. Either the source code or files with an entry point must be set.
+func validateProject(form *CreateJobForm) error {
+	if len(form.SourceCode) > 0 && len(form.Files) > 0 {
+		return errors.New("either source code or files must be provided, not both")
+	}
+
+	size := len(form.SourceCode)
+	if len(form.Files) > 0 {
+		if len(form.Files) > maxProjectFiles {
+			return fmt.Errorf("too many files, the maximum is %d", maxProjectFiles)
+		}
+
+		for path, content := range form.Files {
+			if err := jobs.ValidateFilePath(path); err != nil {
+				return err
+			}
+
+			size += len(path) + len(content)
+		}
+
+		if form.EntryPoint == "" {
+			return errors.New("entry point is not provided")
+		}
+
+		if _, ok := form.Files[form.EntryPoint]; !ok {
+			return fmt.Errorf("entry point '%s' is not one of the files", form.EntryPoint)
+		}
+	} else if form.EntryPoint != "" {
+		return errors.New("entry point requires files")
+	}
+
+	if size > maxProjectSize {
+		return fmt.Errorf("source code is too large, the maximum is %d bytes", maxProjectSize)
+	}
+
+	if len(form.Args) > maxArguments {
+		return fmt.Errorf("too many arguments, the maximum is %d", maxArguments)
+	}
+
+	if len(form.Env) > maxEnvVars {
+		return fmt.Errorf("too many environment variables, the maximum is %d", maxEnvVars)
+	}
+
+	for name := range form.Env {
+		if err := jobs.ValidateEnvVarName(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addProject copies files, arguments and environment variables of the form
+// to the job. The source code of a multi-file job is its entry point.
+func addProject(job *jobs.Job, form *CreateJobForm) {
+	if len(form.Files) > 0 {
+		job.EntryPoint = form.EntryPoint
+		job.SourceCodeB64 = base64.StdEncoding.EncodeToString([]byte(form.Files[form.EntryPoint]))
+		for _, path := range sortedKeys(form.Files) {
+			job.Files = append(
+				job.Files, jobs.JobFile{
+					Path:       path,
+					ContentB64: base64.StdEncoding.EncodeToString([]byte(form.Files[path])),
+				},
+			)
+		}
+	}
+
+	for i, arg := range form.Args {
+		job.Arguments = append(job.Arguments, jobs.JobArgument{Position: i, Value: arg})
+	}
+
+	for _, name := range sortedKeys(form.Env) {
+		job.EnvVars = append(job.EnvVars, jobs.JobEnvVar{Name: name, Value: form.Env[name]})
+	}
+}
+
+// setMessagePayload puts the program into the message. Single-file jobs
+// without arguments and environment use the first version of the message,
+// so that older workers can still run them.
+func setMessagePayload(message *rmq.JobMessage, job *jobs.Job) error {
+	if len(job.Files) == 0 && len(job.Arguments) == 0 && len(job.EnvVars) == 0 {
+		message.Version = rmq.JobMessageV1
+		message.SourceCodeB64 = job.SourceCodeB64
+		return nil
+	}
+
+	message.Version = rmq.JobMessageV2
+	files := map[string][]byte{}
+	for _, file := range job.Files {
+		content, err := base64.StdEncoding.DecodeString(file.ContentB64)
+		if err != nil {
+			return err
+		}
+
+		files[file.Path] = content
+	}
+
+	message.EntryPoint = job.EntryPoint
+	if len(files) == 0 {
+		// A single-file job with arguments or environment.
+		content, err := base64.StdEncoding.DecodeString(job.SourceCodeB64)
+		if err != nil {
+			return err
+		}
+
+		message.EntryPoint = defaultEntryPoint
+		files[defaultEntryPoint] = content
+	}
+
+	archive, err := rmq.NewProjectArchive(files)
+	if err != nil {
+		return err
+	}
+
+	message.ArchiveB64 = base64.StdEncoding.EncodeToString(archive)
+	for _, arg := range job.Arguments {
+		message.Args = append(message.Args, arg.Value)
+	}
+
+	if len(job.EnvVars) > 0 {
+		message.Env = map[string]string{}
+		for _, envVar := range job.EnvVars {
+			message.Env[envVar.Name] = envVar.Value
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
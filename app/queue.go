@@ -0,0 +1,230 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	rmq "borsch-playground-api/rmq"
+	"borsch-playground-api/settings"
+	"github.com/gin-gonic/gin"
+)
+
+// queueSnapshotTtl limits how often queues are inspected and durations
+// are computed.
+const queueSnapshotTtl = 5 * time.Second
+
+// queueSnapshot holds queue depths, nil when the broker could not be
+// asked, and average job durations by language version.
+type queueSnapshot struct {
+	queues    map[string]rmq.QueueStats
+	durations map[string]time.Duration
+}
+
+type queueSnapshotCache struct {
+	mu        sync.Mutex
+	snapshot  *queueSnapshot
+	fetchedAt time.Time
+}
+
+type queueInfo struct {
+	Name             string   `json:"name"`
+	Versions         []string `json:"versions"`
+	Messages         *int     `json:"messages"`
+	Consumers        *int     `json:"consumers"`
+	EstimatedWaitSec *float64 `json:"estimated_wait_sec"`
+}
+
+type versionQueueInfo struct {
+	LangVersion   string   `json:"lang_version"`
+	Queued        int64    `json:"queued"`
+	Running       int64    `json:"running"`
+	AvgDurationMs *float64 `json:"avg_duration_ms"`
+}
+
+func (a *Application) getQueueSnapshot() *queueSnapshot {
+	a.queueSnapshots.mu.Lock()
+	defer a.queueSnapshots.mu.Unlock()
+
+	if a.queueSnapshots.snapshot != nil && time.Since(a.queueSnapshots.fetchedAt) < queueSnapshotTtl {
+		return a.queueSnapshots.snapshot
+	}
+
+	snapshot := &queueSnapshot{}
+	stats, err := a.amqpJobService.GetQueueStats()
+	if err != nil {
+		logging.Warningf("Failed to get queue stats: %v", err)
+	} else {
+		snapshot.queues = map[string]rmq.QueueStats{}
+		for _, queue := range stats {
+			snapshot.queues[queue.Name] = queue
+		}
+	}
+
+	snapshot.durations, err = a.replicaJobService.GetAverageDurations()
+	if err != nil {
+		logging.Warningf("Failed to get job durations: %v", err)
+		snapshot.durations = map[string]time.Duration{}
+	}
+
+	a.queueSnapshots.snapshot = snapshot
+	a.queueSnapshots.fetchedAt = time.Now()
+	return snapshot
+}
+
+// jobQueues returns the job queues with the language versions they receive.
+func jobQueues(s *settings.Settings) []settings.JobQueue {
+	if s.RabbitMQ.JobExchange == "" {
+		return []settings.JobQueue{{Name: s.RabbitMQ.JobQueue, Versions: s.BorschVersions}}
+	}
+
+	return s.RabbitMQ.JobQueues
+}
+
+func jobQueueOf(s *settings.Settings, version string) *settings.JobQueue {
+	for _, queue := range jobQueues(s) {
+		if stringArrayContains(queue.Versions, version) {
+			return &queue
+		}
+	}
+
+	return nil
+}
+
+// estimateWait returns the time needed to run the given number of jobs of
+// the queue, or nil if no job durations are known yet.
+func estimateWait(snapshot *queueSnapshot, queue *settings.JobQueue, version string, jobsAhead int64) *time.Duration {
+	avg, ok := snapshot.durations[version]
+	if version == "" {
+		// The average of all versions of the queue.
+		var total time.Duration
+		known := 0
+		for _, v := range queue.Versions {
+			if d, found := snapshot.durations[v]; found {
+				total += d
+				known++
+			}
+		}
+
+		ok = known > 0
+		if ok {
+			avg = total / time.Duration(known)
+		}
+	}
+
+	if !ok {
+		return nil
+	}
+
+	consumers := 1
+	if stats, found := snapshot.queues[queue.Name]; found && stats.Consumers > 1 {
+		consumers = stats.Consumers
+	}
+
+	wait := time.Duration(jobsAhead) * avg / time.Duration(consumers)
+	return &wait
+}
+
+// estimateQueue tells where the queued job is. The position comes from the
+// database; the number of jobs ahead is capped by the depth of the queue,
+// since jobs which are not in the queue anymore are being run.
+func (a *Application) estimateQueue(job *jobs.Job) (*jobs.QueueEstimate, error) {
+	queue := jobQueueOf(a.settings.Get(), job.LangVersion)
+	if queue == nil {
+		return nil, nil
+	}
+
+	position, err := a.replicaJobService.GetQueuePosition(job, queue.Versions)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate := &jobs.QueueEstimate{Position: position}
+	snapshot := a.getQueueSnapshot()
+	ahead := position - 1
+	if stats, ok := snapshot.queues[queue.Name]; ok && int64(stats.Messages)-1 < ahead {
+		ahead = int64(stats.Messages) - 1
+		if ahead < 0 {
+			ahead = 0
+		}
+	}
+
+	wait := estimateWait(snapshot, queue, job.LangVersion, ahead)
+	if wait != nil {
+		waitSec := wait.Seconds()
+		startAt := time.Now().Add(*wait)
+		estimate.EstimatedWaitSec = &waitSec
+		estimate.EstimatedStartAt = &startAt
+	}
+
+	return estimate, nil
+}
+
+func (a *Application) getQueueHandler(c *gin.Context) {
+	counts, err := a.replicaJobService.GetActiveJobCounts()
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	s := a.settings.Get()
+	snapshot := a.getQueueSnapshot()
+	configured := jobQueues(s)
+	queues := make([]queueInfo, 0, len(configured))
+	for i := range configured {
+		queue := &configured[i]
+		info := queueInfo{Name: queue.Name, Versions: queue.Versions}
+		if stats, ok := snapshot.queues[queue.Name]; ok {
+			info.Messages = &stats.Messages
+			info.Consumers = &stats.Consumers
+			if wait := estimateWait(snapshot, queue, "", int64(stats.Messages)); wait != nil {
+				waitSec := wait.Seconds()
+				info.EstimatedWaitSec = &waitSec
+			}
+		}
+
+		queues = append(queues, info)
+	}
+
+	byVersion := map[string]*versionQueueInfo{}
+	for _, version := range s.BorschVersions {
+		byVersion[version] = &versionQueueInfo{LangVersion: version}
+	}
+
+	for _, count := range counts {
+		info, ok := byVersion[count.LangVersion]
+		if !ok {
+			continue
+		}
+
+		if count.Status == jobs.JobStatusQueued {
+			info.Queued = count.Count
+		} else {
+			info.Running = count.Count
+		}
+	}
+
+	versions := make([]*versionQueueInfo, 0, len(byVersion))
+	for version, info := range byVersion {
+		if avg, ok := snapshot.durations[version]; ok {
+			ms := float64(avg) / float64(time.Millisecond)
+			info.AvgDurationMs = &ms
+		}
+
+		versions = append(versions, info)
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].LangVersion < versions[j].LangVersion })
+	c.JSON(http.StatusOK, gin.H{"queues": queues, "versions": versions})
+}
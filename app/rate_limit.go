@@ -0,0 +1,123 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"borsch-playground-api/settings"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps a token bucket per client. Buckets are dropped when
+// the limits are changed by a settings reload.
+type rateLimiter struct {
+	mu        sync.Mutex
+	config    settings.RateLimit
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{clients: map[string]*clientLimiter{}, lastSweep: time.Now()}
+}
+
+// reserve takes n tokens, one per job, from the client's bucket. If they
+// are not available, it returns false with the time after which they are,
+// or zero if n exceeds the burst, so that the request can never pass.
+func (rl *rateLimiter) reserve(client string, n int, config *settings.RateLimit) (bool, time.Duration) {
+	if !config.Enabled() {
+		return true, 0
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if rl.config != *config {
+		rl.config = *config
+		rl.clients = map[string]*clientLimiter{}
+	}
+
+	if now.Sub(rl.lastSweep) > rateLimiterIdleTimeout {
+		for key, c := range rl.clients {
+			if now.Sub(c.lastSeen) > rateLimiterIdleTimeout {
+				delete(rl.clients, key)
+			}
+		}
+
+		rl.lastSweep = now
+	}
+
+	c, ok := rl.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(config.JobsPerMinute/60), config.Burst)}
+		rl.clients[client] = c
+	}
+
+	c.lastSeen = now
+	r := c.limiter.ReserveN(now, n)
+	if !r.OK() {
+		return false, 0
+	}
+
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+
+	r.CancelAt(now)
+	return false, delay
+}
+
+func (a *Application) rateLimitMiddleware(c *gin.Context) {
+	if !a.reserveJobs(c, 1) {
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// reserveJobs takes tokens for n jobs of the client from the rate limit and
+// sends an error if they are not available.
+func (a *Application) reserveJobs(c *gin.Context, n int) bool {
+	config := a.settings.Get().RateLimit
+	ok, retryAfter := a.rateLimiter.reserve(c.ClientIP(), n, config)
+	if ok {
+		return true
+	}
+
+	if retryAfter == 0 {
+		a.sendJsonError(
+			c,
+			http.StatusTooManyRequests,
+			fmt.Errorf("%d jobs exceed the rate limit of %d jobs at once", n, config.Burst),
+		)
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	a.sendJsonError(c, http.StatusTooManyRequests, errors.New("rate limit exceeded, try again later"))
+	return false
+}
@@ -0,0 +1,73 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	"gorm.io/gorm"
+)
+
+// applyCachedResult looks up the result of an identical program. On a hit
+// the job is finished right away and references the outputs of the cached
+// job, otherwise the job gets the cache key to store its result under.
+// Jobs with test cases and jobs created with no_cache are never cached.
+func (a *Application) applyCachedResult(form *CreateJobForm, job *jobs.Job) {
+	if !a.settings.Get().ResultCache.Enabled() || form.NoCache || job.Kind != jobs.JobKindRun {
+		return
+	}
+
+	key, err := resultCacheKey(job)
+	if err != nil {
+		logging.Errorf("Failed to compute cache key: %v", err)
+		return
+	}
+
+	entry, err := a.jobService.GetCachedResult(key)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Errorf("Failed to look up cached result: %v", err)
+		}
+
+		job.CacheKey = key
+		return
+	}
+
+	// The job is never published.
+	job.Attempts = 0
+	job.Status = jobs.JobStatusFinished
+	job.ExitCode = &entry.ExitCode
+	job.Cached = true
+	job.CachedFromID = &entry.JobID
+}
+
+// resultCacheKey hashes everything the worker gets to run the job: the
+// language version, the program, its arguments and environment.
+func resultCacheKey(job *jobs.Job) (string, error) {
+	message, err := newJobMessage(job)
+	if err != nil {
+		return "", err
+	}
+
+	message.ID = ""
+	message.Priority = 0
+	message.Attempt = 0
+	data, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errNotRejected = errors.New("only rejected jobs can be retried")
+
+// runRetrier periodically publishes again rejected jobs whose retry is due.
+// The policy is read before every run to pick up reloaded settings.
+func (a *Application) runRetrier(ctx context.Context) {
+	for {
+		policy := a.retryPolicy()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(policy.Interval):
+		}
+
+		now := time.Now()
+		ids, err := a.jobService.GetDueRetries(now, policy.BatchSize)
+		if err != nil {
+			logging.Errorf("Failed to get jobs to retry: %v", err)
+			continue
+		}
+
+		for _, id := range ids {
+			job, err := a.retryJob(id, "retried automatically", &now)
+			if err != nil {
+				if !errors.Is(err, errNotRejected) {
+					logging.Errorf("Failed to retry job %s: %v", id, err)
+				}
+
+				continue
+			}
+
+			logging.Infof("Job %s was retried, attempt %d, status %s", job.ID, job.Attempts, job.Status)
+		}
+	}
+}
+
+// retryJob claims the rejected job, drops results of its previous run and
+// publishes it again. If dueBy is set, only a job whose retry is due by
+// then is retried.
+func (a *Application) retryJob(id, reason string, dueBy *time.Time) (*jobs.Job, error) {
+	job, err := a.jobService.GetJobWithProject(id)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := a.jobService.ClaimRejectedJob(job, reason, dueBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claimed {
+		return nil, errNotRejected
+	}
+
+	err = a.jobService.ResetJob(job)
+	if err != nil {
+		// Reject the job again, so that it is not stuck as accepted.
+		job.ScheduleRetry(err, a.retryPolicy(), time.Now())
+		updateErr := a.jobService.TransitionJob(
+			job, jobs.JobStatusRejected, "retry failed", "last_error", "next_retry_at",
+		)
+		if updateErr != nil {
+			logging.Errorf("Failed to update job %s: %v", id, updateErr)
+		}
+
+		return nil, err
+	}
+
+	a.publishJob(job)
+	return job, nil
+}
+
+// retryJobHandler publishes a rejected job again right away, regardless of
+// the number of attempts.
+func (a *Application) retryJobHandler(c *gin.Context) {
+	job, err := a.jobService.GetJob(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.sendJsonError(c, http.StatusNotFound, errors.New("job not found"))
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
+		return
+	}
+
+	if job.Status != jobs.JobStatusRejected {
+		a.sendJsonError(c, http.StatusConflict, errNotRejected)
+		return
+	}
+
+	if !stringArrayContains(a.settings.Get().BorschVersions, job.LangVersion) {
+		a.sendJsonError(
+			c,
+			http.StatusConflict,
+			fmt.Errorf("language version '%s' is not available", job.LangVersion),
+		)
+		return
+	}
+
+	job, err = a.retryJob(job.ID, "retried by the client", nil)
+	if err != nil {
+		if errors.Is(err, errNotRejected) {
+			a.sendJsonError(c, http.StatusConflict, err)
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
+		return
+	}
+
+	a.addJobLinks(c, job)
+	c.JSON(http.StatusOK, job)
+}
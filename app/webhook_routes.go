@@ -0,0 +1,94 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxCallbackUrlLength = 2048
+
+func (a *Application) validateCallbackUrl(callbackUrl string) error {
+	if callbackUrl == "" {
+		return nil
+	}
+
+	if !a.settings.Get().Webhooks.Enabled() {
+		return errors.New("callback URLs are not supported")
+	}
+
+	if len(callbackUrl) > maxCallbackUrlLength {
+		return errors.New("callback URL is too long")
+	}
+
+	u, err := url.Parse(callbackUrl)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.New("callback URL must be an absolute HTTP or HTTPS URL")
+	}
+
+	// Hosts are not resolved here; the dispatcher checks the addresses they
+	// resolve to when it connects.
+	return jobs.CheckWebhookHost(u.Hostname())
+}
+
+func (a *Application) getJobWebhooksHandler(c *gin.Context) {
+	job, err := a.jobService.GetJob(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			a.sendJsonError(c, http.StatusNotFound, errors.New("job not found"))
+		} else {
+			a.sendJsonError(c, http.StatusInternalServerError, err)
+		}
+
+		return
+	}
+
+	deliveries, err := a.jobService.GetWebhookDeliveries(job.ID)
+	if err != nil {
+		a.sendJsonError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"callback_url": job.CallbackUrl, "deliveries": deliveries})
+}
+
+// enqueueWebhook schedules the callback of a job finished without the
+// consumer, i.e. from the cache or by an operator.
+func (a *Application) enqueueWebhook(job *jobs.Job) {
+	if job.CallbackUrl == "" {
+		return
+	}
+
+	var err error
+	if job.Kind == jobs.JobKindTest {
+		var testCases []jobs.JobTestCase
+		testCases, err = a.jobService.GetTestCases(job.ID)
+		job.TestSummary = jobs.Summarize(testCases)
+	}
+
+	var delivery *jobs.WebhookDelivery
+	if err == nil {
+		delivery, err = jobs.NewWebhookDelivery(job)
+	}
+
+	if err == nil {
+		err = a.jobService.CreateWebhookDelivery(delivery)
+	}
+
+	if err != nil {
+		logging.Errorf("Failed to enqueue webhook of job %s: %v", job.ID, err)
+	}
+}
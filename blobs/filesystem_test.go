@@ -0,0 +1,117 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package blobs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// invalidKeys are keys which would escape the directory of the store or
+// are not normalized.
+var invalidKeys = []string{
+	"",
+	"/",
+	"..",
+	"../outside",
+	"../../etc/passwd",
+	"outputs/../../outside",
+	"outputs/..",
+	"/etc/passwd",
+	"outputs/",
+	"outputs//key",
+	"./outputs/key",
+	"outputs/./key",
+}
+
+func TestValidateKey(t *testing.T) {
+	for _, key := range invalidKeys {
+		if validateKey(key) == nil {
+			t.Errorf("key %q is valid", key)
+		}
+	}
+
+	for _, key := range []string{"outputs/ab/cdef", "key", "outputs/..hidden", "outputs/a..b", "sources/.x"} {
+		if err := validateKey(key); err != nil {
+			t.Errorf("key %q is invalid: %v", key, err)
+		}
+	}
+}
+
+func TestFileStoreStaysInDirectory(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewFileStore(filepath.Join(root, "blobs", "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outside := filepath.Join(root, "blobs", "outside")
+	if err = ioutil.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range invalidKeys {
+		if err = store.Put(key, []byte("data")); err == nil {
+			t.Errorf("put of %q succeeded", key)
+		}
+
+		if _, err = store.Get(key); err == nil {
+			t.Errorf("get of %q succeeded", key)
+		}
+
+		if err = store.Delete(key); err == nil {
+			t.Errorf("delete of %q succeeded", key)
+		}
+	}
+
+	data, err := ioutil.ReadFile(outside)
+	if err != nil || string(data) != "secret" {
+		t.Errorf("file outside of the store is %q, %v", data, err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "blobs"))
+	if err != nil || len(entries) != 2 {
+		t.Errorf("files were created outside of the store: %v, %v", entries, err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Put("outputs/ab/cdef", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Put("outputs/ab/cdef", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := store.Get("outputs/ab/cdef")
+	if err != nil || string(data) != "second" {
+		t.Fatalf("get returned %q, %v", data, err)
+	}
+
+	if err = store.Delete("outputs/ab/cdef"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.Get("outputs/ab/cdef"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get of a deleted blob returned %v", err)
+	}
+
+	if err = store.Delete("outputs/ab/cdef"); err != nil {
+		t.Errorf("delete of a missing blob returned %v", err)
+	}
+}
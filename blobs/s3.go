@@ -0,0 +1,204 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package blobs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	s3Timeout = 30 * time.Second
+
+	// maxS3ErrorBytes limits how much of an error response is reported.
+	maxS3ErrorBytes = 512
+)
+
+// S3Store keeps blobs as objects of Bucket in an S3-compatible storage,
+// e.g. AWS S3 or MinIO. Objects are addressed path-style, as
+// Endpoint/Bucket/key, and requests are signed with AWS Signature
+// Version 4.
+type S3Store struct {
+	Endpoint        *url.URL
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client
+}
+
+func NewS3Store(endpoint, region, bucket, accessKeyID, secretAccessKey string) (*S3Store, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{
+		Endpoint:        u,
+		Region:          region,
+		Bucket:          bucket,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Client:          &http.Client{Timeout: s3Timeout},
+	}, nil
+}
+
+func (s *S3Store) Put(key string, data []byte) error {
+	response, err := s.do(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	return checkS3Response(response, http.StatusOK)
+}
+
+func (s *S3Store) Get(key string) ([]byte, error) {
+	response, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	err = checkS3Response(response, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+func (s *S3Store) Delete(key string) error {
+	response, err := s.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return checkS3Response(response, http.StatusOK, http.StatusNoContent)
+}
+
+func (s *S3Store) do(method, key string, body []byte) (*http.Response, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	u := *s.Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket + "/" + key
+	// The path is sent as it is signed.
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = ""
+	request, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	s.sign(request, body, time.Now().UTC())
+	return s.Client.Do(request)
+}
+
+// sign adds the AWS Signature Version 4 of the request, which has no query
+// and whose payload is hashed.
+func (s *S3Store) sign(request *http.Request, body []byte, now time.Time) {
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	request.Header.Set("X-Amz-Date", timestamp)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join(
+		[]string{
+			request.Method,
+			request.URL.EscapedPath(),
+			"",
+			"host:" + request.URL.Host,
+			"x-amz-content-sha256:" + payloadHash,
+			"x-amz-date:" + timestamp,
+			"",
+			signedHeaders,
+			payloadHash,
+		},
+		"\n",
+	)
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			s.AccessKeyID,
+			scope,
+			signedHeaders,
+			hex.EncodeToString(hmacSHA256(key, stringToSign)),
+		),
+	)
+}
+
+// escapePath encodes every byte of the path except unreserved characters
+// and slashes, as Signature Version 4 requires.
+func escapePath(path string) string {
+	var builder strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", c)
+		}
+	}
+
+	return builder.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func checkS3Response(response *http.Response, expected ...int) error {
+	for _, status := range expected {
+		if response.StatusCode == status {
+			return nil
+		}
+	}
+
+	message, _ := ioutil.ReadAll(&io.LimitedReader{R: response.Body, N: maxS3ErrorBytes})
+	return fmt.Errorf("S3 responded with %s: %s", response.Status, strings.TrimSpace(string(message)))
+}
@@ -0,0 +1,91 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/settings"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dryRunArg bool
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Remove jobs and outputs according to the retention settings",
+	Args:  cobra.NoArgs,
+	RunE:  cleanup,
+}
+
+func init() {
+	cleanupCmd.Flags().BoolVar(
+		&dryRunArg, "dry-run", false, "only report how many jobs would be removed",
+	)
+	rootCmd.AddCommand(cleanupCmd)
+}
+
+func cleanup(*cobra.Command, []string) error {
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+
+	db, err := s.Database.Build()
+	if err != nil {
+		return err
+	}
+
+	blobConfig, err := newBlobConfig(s)
+	if err != nil {
+		return err
+	}
+
+	jobService := jobs.NewJobServiceImpl(settings.UsePrimary(db)).WithBlobs(blobConfig)
+	policy, _ := retentionPolicy(s)
+	result, err := jobService.CleanUp(policy, dryRunArg)
+	if err != nil {
+		return err
+	}
+
+	verb := "removed"
+	if dryRunArg {
+		verb = "would remove"
+	}
+
+	fmt.Printf(
+		"%s %d job(s): %d deleted by clients, %d expired, %d over the per-client limit\n",
+		verb, result.Jobs(), result.Deleted, result.Expired, result.OverLimit,
+	)
+	if !dryRunArg {
+		fmt.Printf("removed %d output row(s) and %d output chunk(s)\n", result.OutputRows, result.OutputChunks)
+	}
+
+	return nil
+}
+
+func retentionPolicy(s *settings.Settings) (jobs.RetentionPolicy, time.Duration) {
+	r := s.Retention
+	statuses := make([]jobs.JobStatus, len(r.Statuses))
+	for i, status := range r.Statuses {
+		statuses[i] = jobs.JobStatus(status)
+	}
+
+	policy := jobs.RetentionPolicy{
+		MaxAge:           time.Duration(r.MaxAgeHours) * time.Hour,
+		MaxJobsPerClient: r.MaxJobsPerClient,
+		Statuses:         statuses,
+		BatchSize:        r.BatchSize,
+	}
+	return policy, time.Duration(r.IntervalSec) * time.Second
+}
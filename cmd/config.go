@@ -0,0 +1,66 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the effective configuration with secrets redacted",
+	Args:  cobra.NoArgs,
+	RunE:  configPrint,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration and report all problems",
+	Args:  cobra.NoArgs,
+	RunE:  configValidate,
+}
+
+func init() {
+	configCmd.AddCommand(configPrintCmd, configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func configPrint(*cobra.Command, []string) error {
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+
+	bytes, err := json.MarshalIndent(s.Redacted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(bytes))
+	return nil
+}
+
+func configValidate(cmd *cobra.Command, _ []string) error {
+	_, err := loadSettings()
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	fmt.Println("configuration is valid")
+	return nil
+}
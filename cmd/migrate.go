@@ -9,31 +9,145 @@
 package cmd
 
 import (
+	"fmt"
+	"strconv"
+
 	"borsch-playground-api/migrations"
 	"borsch-playground-api/settings"
 	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var (
+	migrationsDirArg string
 )
 
 var migrateCmd = &cobra.Command{
 	Use:   "migrate",
 	Short: "Migrate the database",
-	RunE:  migrate,
+	RunE:  migrateUp,
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply all pending migrations",
+	Args:  cobra.NoArgs,
+	RunE:  migrateUp,
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down [N]",
+	Short: "Roll back the last N applied migrations (1 by default)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  migrateDown,
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show applied and pending migrations",
+	Args:  cobra.NoArgs,
+	RunE:  migrateStatus,
+}
+
+var migrateCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "Create empty up and down migration files",
+	Args:  cobra.ExactArgs(1),
+	RunE:  migrateCreate,
 }
 
 func init() {
+	migrateCreateCmd.Flags().StringVarP(
+		&migrationsDirArg, "dir", "d", "migrations", "migrations source directory",
+	)
+
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd, migrateCreateCmd)
 	rootCmd.AddCommand(migrateCmd)
 }
 
-func migrate(*cobra.Command, []string) error {
-	s, err := settings.Load()
+func migrateUp(*cobra.Command, []string) error {
+	db, err := openDatabase()
 	if err != nil {
 		return err
 	}
 
-	db, err := s.Database.Build()
+	applied, err := migrations.Up(db)
+	for _, m := range applied {
+		fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+	}
+
+	if err == nil && len(applied) == 0 {
+		fmt.Println("no pending migrations")
+	}
+
+	return err
+}
+
+func migrateDown(_ *cobra.Command, args []string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of migrations: %s", args[0])
+		}
+	}
+
+	db, err := openDatabase()
 	if err != nil {
 		return err
 	}
 
-	return migrations.Migrate(db)
+	reverted, err := migrations.Down(db, n)
+	for _, m := range reverted {
+		fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+	}
+
+	return err
+}
+
+func migrateStatus(*cobra.Command, []string) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	statuses, err := migrations.Status(db)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		state := "pending"
+		if s.AppliedAt != nil {
+			state = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+	}
+
+	return nil
+}
+
+func migrateCreate(_ *cobra.Command, args []string) error {
+	files, err := migrations.Create(migrationsDirArg, args[0])
+	for _, file := range files {
+		fmt.Printf("created %s\n", file)
+	}
+
+	return err
+}
+
+func openDatabase() (*gorm.DB, error) {
+	s, err := loadSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := s.Database.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return settings.UsePrimary(db), nil
 }
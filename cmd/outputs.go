@@ -0,0 +1,149 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/migrations"
+	"borsch-playground-api/settings"
+	"github.com/spf13/cobra"
+)
+
+var (
+	outputsBatchSizeArg int
+	outputsDropIndexArg bool
+)
+
+var outputsCmd = &cobra.Command{
+	Use:   "outputs",
+	Short: "Manage stored outputs of jobs",
+}
+
+var outputsCompressCmd = &cobra.Command{
+	Use:   "compress",
+	Short: "Move output rows of finished jobs into compressed chunks",
+	Args:  cobra.NoArgs,
+	RunE:  compressOutputs,
+}
+
+var outputsArchiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Move outputs of finished jobs into the blob store",
+	Args:  cobra.NoArgs,
+	RunE:  archiveOutputs,
+}
+
+var outputsSearchIndexCmd = &cobra.Command{
+	Use:   "search-index",
+	Short: "Create the trigram index which speeds up searches of output rows (PostgreSQL)",
+	Args:  cobra.NoArgs,
+	RunE:  outputsSearchIndex,
+}
+
+func init() {
+	for _, command := range []*cobra.Command{outputsCompressCmd, outputsArchiveCmd} {
+		command.Flags().IntVar(
+			&outputsBatchSizeArg, "batch-size", 100, "number of jobs selected at once",
+		)
+	}
+
+	outputsSearchIndexCmd.Flags().BoolVar(
+		&outputsDropIndexArg, "drop", false, "drop the index instead of creating it",
+	)
+
+	outputsCmd.AddCommand(outputsCompressCmd, outputsArchiveCmd, outputsSearchIndexCmd)
+	rootCmd.AddCommand(outputsCmd)
+}
+
+func compressOutputs(*cobra.Command, []string) error {
+	s, jobService, err := openOutputs()
+	if err != nil {
+		return err
+	}
+
+	result, err := jobService.CompressOutputs(s.Outputs.ChunkLines, outputsBatchSizeArg)
+	fmt.Printf("moved %d output row(s) of %d job(s) into %d chunk(s)\n", result.Rows, result.Jobs, result.Chunks)
+	return err
+}
+
+func archiveOutputs(*cobra.Command, []string) error {
+	s, jobService, err := openOutputs()
+	if err != nil {
+		return err
+	}
+
+	if !s.Blobs.Enabled() || !s.Blobs.ArchiveOutputs {
+		return errors.New("archiving outputs is disabled, see blobs.archive_outputs")
+	}
+
+	archived, err := jobService.ArchiveOutputs(outputsBatchSizeArg)
+	fmt.Printf("archived outputs of %d job(s)\n", archived)
+	return err
+}
+
+func outputsSearchIndex(*cobra.Command, []string) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	if outputsDropIndexArg {
+		err = migrations.DropOutputSearchIndex(db)
+		if err == nil {
+			fmt.Println("dropped the output search index")
+		}
+
+		return err
+	}
+
+	err = migrations.CreateOutputSearchIndex(db)
+	if err == nil {
+		fmt.Println("created the output search index")
+	}
+
+	return err
+}
+
+// openOutputs returns the settings and the job service for the outputs
+// commands.
+func openOutputs() (*settings.Settings, *jobs.JobServiceImpl, error) {
+	s, err := loadSettings()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if outputsBatchSizeArg <= 0 {
+		return nil, nil, errors.New("batch size must be positive")
+	}
+
+	db, err := s.Database.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blobConfig, err := newBlobConfig(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s, jobs.NewJobServiceImpl(settings.UsePrimary(db)).WithBlobs(blobConfig), nil
+}
+
+// outputChunkLines returns the number of lines per output chunk, zero if
+// outputs are stored as rows.
+func outputChunkLines(s *settings.Settings) int {
+	if !s.Outputs.Chunked() {
+		return 0
+	}
+
+	return s.Outputs.ChunkLines
+}
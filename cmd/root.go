@@ -9,17 +9,22 @@
 package cmd
 
 import (
-	"os"
+	"context"
+	"time"
 
 	"borsch-playground-api/app"
 	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	"borsch-playground-api/migrations"
 	rmq "borsch-playground-api/rmq"
 	"borsch-playground-api/settings"
 	"github.com/spf13/cobra"
 )
 
 var (
-	addressArg string
+	addressArg  string
+	configArg   string
+	overrideArg []string
 )
 
 var rootCmd = &cobra.Command{
@@ -35,10 +40,44 @@ func init() {
 	rootCmd.Flags().StringVarP(
 		&addressArg, "bind", "b", "127.0.0.1:8080", "bind address",
 	)
+	rootCmd.PersistentFlags().StringVarP(
+		&configArg, "config", "c", "", "settings file (default $"+settings.EnvConfig+" or settings.json)",
+	)
+	rootCmd.PersistentFlags().StringArrayVar(
+		&overrideArg, "set", nil, "override a setting, e.g. --set database.postgresql.host=localhost",
+	)
+}
+
+func loadSettings() (*settings.Settings, error) {
+	s, err := settings.Load(configArg, overrideArg)
+	if err != nil {
+		return nil, err
+	}
+
+	applyLogLevel(s)
+	return s, nil
+}
+
+func applyLogLevel(s *settings.Settings) {
+	level, err := logging.ParseLevel(s.LogLevel)
+	if err == nil {
+		logging.SetLevel(level)
+	}
 }
 
 func root(*cobra.Command, []string) error {
-	s, err := settings.Load()
+	holder, err := settings.NewHolder(configArg, overrideArg)
+	if err != nil {
+		return err
+	}
+
+	s := holder.Get()
+	applyLogLevel(s)
+	holder.OnReload(applyLogLevel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = holder.Watch(ctx)
 	if err != nil {
 		return err
 	}
@@ -48,10 +87,36 @@ func root(*cobra.Command, []string) error {
 		return err
 	}
 
-	jobService := jobs.NewJobServiceImpl(db)
+	err = migrations.CheckUpToDate(settings.UsePrimary(db))
+	if err != nil {
+		return err
+	}
+
+	blobConfig, err := newBlobConfig(s)
+	if err != nil {
+		return err
+	}
+
+	jobService := jobs.NewJobServiceImpl(db).WithBlobs(blobConfig)
 	amqpJobService := rmq.RabbitMQJobService{
-		Server:     os.Getenv(rmq.EnvRabbitMQServer),
-		JobService: jobService,
+		Server:      s.RabbitMQ.Server,
+		JobQueue:    s.RabbitMQ.JobQueue,
+		ResultQueue: s.RabbitMQ.ResultQueue,
+		JobExchange: s.RabbitMQ.JobExchange,
+		JobQueues:   jobQueues(s.RabbitMQ),
+		MaxPriority: uint8(s.RabbitMQ.MaxPriority),
+		JobService:  jobService,
+		ResultCache: func() jobs.ResultCachePolicy {
+			return resultCachePolicy(holder.Get())
+		},
+		Retry: func() jobs.RetryPolicy {
+			return retryPolicy(holder.Get())
+		},
+		ResultConsumers:     s.RabbitMQ.ResultConsumers,
+		ResultPrefetch:      s.RabbitMQ.ResultPrefetch,
+		ResultBatchSize:     s.RabbitMQ.ResultBatchSize,
+		ResultFlushInterval: time.Duration(s.RabbitMQ.ResultFlushMs) * time.Millisecond,
+		OutputChunkLines:    outputChunkLines(s),
 	}
 	err = amqpJobService.Setup()
 	if err != nil {
@@ -64,10 +129,86 @@ func root(*cobra.Command, []string) error {
 		return err
 	}
 
-	a, err := app.NewApp(s, db, jobService, &amqpJobService)
+	janitor := jobs.Janitor{
+		JobService: amqpJobService.JobService,
+		Policy: func() (jobs.RetentionPolicy, time.Duration) {
+			return retentionPolicy(holder.Get())
+		},
+	}
+	go janitor.Run(ctx)
+
+	dispatcher := jobs.WebhookDispatcher{
+		JobService: amqpJobService.JobService,
+		Config: func() jobs.WebhookConfig {
+			return webhookConfig(holder.Get())
+		},
+	}
+	go dispatcher.Run(ctx)
+
+	replicaJobService := jobs.NewJobServiceImpl(settings.UseReplicas(db))
+	a, err := app.NewApp(holder, db, jobService, replicaJobService, &amqpJobService, amqpJobService.Retry)
 	if err != nil {
 		return err
 	}
 
 	return a.Execute(addressArg)
 }
+
+func webhookConfig(s *settings.Settings) jobs.WebhookConfig {
+	w := s.Webhooks
+	return jobs.WebhookConfig{
+		Secret:         w.Secret,
+		MaxAttempts:    w.MaxAttempts,
+		InitialBackoff: time.Duration(w.InitialBackoffSec) * time.Second,
+		MaxBackoff:     time.Duration(w.MaxBackoffSec) * time.Second,
+		Timeout:        time.Duration(w.TimeoutSec) * time.Second,
+		Interval:       time.Duration(w.IntervalSec) * time.Second,
+		BatchSize:      w.BatchSize,
+	}
+}
+
+func retryPolicy(s *settings.Settings) jobs.RetryPolicy {
+	r := s.Retries
+	return jobs.RetryPolicy{
+		MaxAttempts:    r.MaxAttempts,
+		InitialBackoff: time.Duration(r.InitialBackoffSec) * time.Second,
+		MaxBackoff:     time.Duration(r.MaxBackoffSec) * time.Second,
+		Interval:       time.Duration(r.IntervalSec) * time.Second,
+		BatchSize:      r.BatchSize,
+	}
+}
+
+func resultCachePolicy(s *settings.Settings) jobs.ResultCachePolicy {
+	r := s.ResultCache
+	if !r.Enabled() {
+		return jobs.ResultCachePolicy{}
+	}
+
+	return jobs.ResultCachePolicy{
+		TTL:            time.Duration(r.TtlSec) * time.Second,
+		MaxEntries:     r.MaxEntries,
+		MaxOutputBytes: int64(r.MaxOutputBytes),
+	}
+}
+
+func newBlobConfig(s *settings.Settings) (jobs.BlobConfig, error) {
+	store, err := s.Blobs.Build()
+	if err != nil || store == nil {
+		return jobs.BlobConfig{}, err
+	}
+
+	return jobs.BlobConfig{
+		Store:           store,
+		SourceThreshold: s.Blobs.SourceThresholdBytes,
+		ArchiveOutputs:  s.Blobs.ArchiveOutputs,
+	}, nil
+}
+
+func jobQueues(r *settings.RabbitMQ) []rmq.JobQueue {
+	queues := make([]rmq.JobQueue, len(r.JobQueues))
+	for i, queue := range r.JobQueues {
+		queues[i] = rmq.JobQueue{Name: queue.Name, Versions: queue.Versions}
+	}
+
+	return queues
+}
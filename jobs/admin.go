@@ -0,0 +1,216 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"errors"
+	"time"
+
+	"borsch-playground-api/blobs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maintenanceModeID is the ID of the only row of the maintenance mode.
+const maintenanceModeID = 1
+
+// MaintenanceMode is stored in the database, so that it is shared by all
+// instances of the API.
+type MaintenanceMode struct {
+	ID        uint           `json:"-" gorm:"primaryKey"`
+	CreatedAt time.Time      `json:"-"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	Enabled   bool           `json:"enabled"`
+	Message   string         `json:"message"`
+}
+
+// JobFilter selects jobs for operators, zero fields match any job.
+type JobFilter struct {
+	Statuses      []JobStatus
+	LangVersion   string
+	Client        string
+	BatchID       string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedBefore *time.Time
+}
+
+// GetMaintenanceMode returns the maintenance mode, which is disabled if it
+// was never set.
+func (js *JobServiceImpl) GetMaintenanceMode() (*MaintenanceMode, error) {
+	mode := &MaintenanceMode{}
+	return mode, js.db.Limit(1).Find(mode, maintenanceModeID).Error
+}
+
+func (js *JobServiceImpl) SetMaintenanceMode(mode *MaintenanceMode) error {
+	mode.ID = maintenanceModeID
+	return js.db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_at", "enabled", "message"}),
+		},
+	).Create(mode).Error
+}
+
+// ListJobs returns a page of the jobs matching the filter, newest first,
+// and the number of all matching jobs.
+func (js *JobServiceImpl) ListJobs(filter JobFilter, offset, limit int) ([]Job, int64, error) {
+	query := js.db.Model(&Job{})
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+
+	if filter.LangVersion != "" {
+		query = query.Where("lang_version = ?", filter.LangVersion)
+	}
+
+	if filter.Client != "" {
+		query = query.Where("client = ?", filter.Client)
+	}
+
+	if filter.BatchID != "" {
+		query = query.Where("batch_id = ?", filter.BatchID)
+	}
+
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at < ?", *filter.CreatedBefore)
+	}
+
+	if filter.UpdatedBefore != nil {
+		query = query.Where("updated_at < ?", *filter.UpdatedBefore)
+	}
+
+	var total int64
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var found []Job
+	err = query.Order("created_at DESC, id").Offset(offset).Limit(limit).Find(&found).Error
+	return found, total, err
+}
+
+// GetJobWithProject returns the job with everything needed to publish it
+// again: its source, files, arguments, environment and test cases.
+func (js *JobServiceImpl) GetJobWithProject(id string) (*Job, error) {
+	job := &Job{}
+	err := js.db.
+		Preload("Files").
+		Preload("Arguments", func(tx *gorm.DB) *gorm.DB { return tx.Order("position") }).
+		Preload("EnvVars").
+		Preload("TestCases", func(tx *gorm.DB) *gorm.DB { return tx.Order("case_index") }).
+		First(job, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return job, js.LoadSource(job)
+}
+
+// ResetJob drops the results of the job, so that it can be run again.
+func (js *JobServiceImpl) ResetJob(job *Job) error {
+	return js.db.Transaction(
+		func(tx *gorm.DB) error {
+			_, err := deleteOutputs(tx, job.ID)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Model(&JobTestCase{}).Where("job_id = ?", job.ID).Updates(
+				map[string]interface{}{"actual_stdout": nil, "actual_exit_code": nil, "passed": nil},
+			).Error
+			if err != nil {
+				return err
+			}
+
+			for i := range job.TestCases {
+				job.TestCases[i].ActualStdout = nil
+				job.TestCases[i].ActualExitCode = nil
+				job.TestCases[i].Passed = nil
+			}
+
+			job.ExitCode = nil
+			job.StartedAt = nil
+			job.FinishedAt = nil
+			job.DurationMs = nil
+			return tx.Model(job).Select("exit_code", "started_at", "finished_at", "duration_ms").Updates(job).Error
+		},
+	)
+}
+
+// DeleteJobOutputs removes the outputs of the job, archived ones included,
+// and the cache entries which would serve them, and returns the number of
+// removed lines.
+func (js *JobServiceImpl) DeleteJobOutputs(jobId string) (int64, error) {
+	job, err := js.GetJob(jobId)
+	if err != nil {
+		return 0, err
+	}
+
+	var archived int64
+	if job.OutputBlobKey != "" {
+		archived, err = js.countArchivedLines(job)
+		if err != nil && !errors.Is(err, blobs.ErrNotFound) {
+			return 0, err
+		}
+	}
+
+	var deleted int64
+	err = js.db.Transaction(
+		func(tx *gorm.DB) error {
+			var err error
+			deleted, err = deleteOutputs(tx, jobId)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Model(&Job{}).Where("id = ?", jobId).Updates(
+				map[string]interface{}{"output_blob_key": "", "output_lines": 0, "output_part_lines": 0},
+			).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Unscoped().Where("job_id = ?", jobId).Delete(&ResultCacheEntry{}).Error
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	js.deleteBlobs(job.outputBlobKeys()...)
+	return deleted + archived, nil
+}
+
+// deleteOutputs removes the output rows and chunks of the job and returns
+// the number of removed lines.
+func deleteOutputs(tx *gorm.DB, jobId string) (int64, error) {
+	var chunkedLines int64
+	err := tx.Model(&JobOutputChunk{}).
+		Where("job_id = ?", jobId).
+		Select("COALESCE(SUM(line_count), 0)").
+		Scan(&chunkedLines).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Unscoped().Where("job_id = ?", jobId).Delete(&JobOutputChunk{}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	rows := tx.Unscoped().Where("job_id = ?", jobId).Delete(&JobOutputRow{})
+	return rows.RowsAffected + chunkedLines, rows.Error
+}
@@ -0,0 +1,397 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"borsch-playground-api/blobs"
+	"borsch-playground-api/logging"
+	"gorm.io/gorm"
+)
+
+var errBlobsDisabled = errors.New("data of the job is in the blob store, which is not configured")
+
+// archivePartLines is the number of lines of each blob of an archived
+// output, so that a page of the output is read without the whole of it.
+const archivePartLines = 10000
+
+// BlobConfig moves large data of jobs out of the database into Store.
+// Projects whose source and files are longer than SourceThreshold bytes
+// together are stored there, zero keeps them in the database; with
+// ArchiveOutputs, outputs of finished jobs are stored there as well.
+// Without Store everything stays in the database.
+type BlobConfig struct {
+	Store           blobs.BlobStore
+	SourceThreshold int
+	ArchiveOutputs  bool
+}
+
+// WithBlobs returns a service which uses the blob store.
+func (js *JobServiceImpl) WithBlobs(config BlobConfig) *JobServiceImpl {
+	return &JobServiceImpl{db: js.db, blobs: config}
+}
+
+// OutputArchived reports whether the output of the job is stored as a blob,
+// which is read whole whatever part of it is requested.
+func (m *Job) OutputArchived() bool {
+	return m.OutputBlobKey != ""
+}
+
+func sourceBlobKey(jobId string) string {
+	return "sources/" + jobId
+}
+
+func fileBlobKey(jobId string, index int) string {
+	return fmt.Sprintf("files/%s/%d", jobId, index)
+}
+
+func outputBlobKey(jobId string) string {
+	return "outputs/" + jobId
+}
+
+// outputBlobKeys returns the keys of the blobs of the archived output. An
+// output archived without parts is a single blob.
+func (m *Job) outputBlobKeys() []string {
+	if m.OutputBlobKey == "" || m.OutputPartLines <= 0 {
+		return []string{m.OutputBlobKey}
+	}
+
+	parts := (m.OutputLines + int64(m.OutputPartLines) - 1) / int64(m.OutputPartLines)
+	keys := make([]string, parts)
+	for i := range keys {
+		keys[i] = m.OutputBlobKey + "/" + strconv.Itoa(i)
+	}
+
+	return keys
+}
+
+// projectBlobKeys returns the keys of the blobs of the source and files of
+// the job.
+func (m *Job) projectBlobKeys() []string {
+	keys := []string{m.SourceBlobKey}
+	for _, file := range m.Files {
+		keys = append(keys, file.BlobKey)
+	}
+
+	return keys
+}
+
+// offloadProject stores the source and files of the new job in the blob
+// store if they are large together, and clears them, so that the job is
+// inserted without them. The returned function puts them back into the
+// job.
+func (js *JobServiceImpl) offloadProject(job *Job) (func(), error) {
+	threshold := js.blobs.SourceThreshold
+	size := len(job.SourceCodeB64)
+	for _, file := range job.Files {
+		size += len(file.ContentB64)
+	}
+
+	if js.blobs.Store == nil || threshold <= 0 || size <= threshold {
+		return func() {}, nil
+	}
+
+	var err error
+	source := job.SourceCodeB64
+	contents := make([]string, len(job.Files))
+	if source != "" {
+		err = js.blobs.Store.Put(sourceBlobKey(job.ID), []byte(source))
+		if err != nil {
+			return nil, err
+		}
+
+		job.SourceCodeB64 = ""
+		job.SourceBlobKey = sourceBlobKey(job.ID)
+	}
+
+	for i := range job.Files {
+		file := &job.Files[i]
+		contents[i] = file.ContentB64
+		if contents[i] == "" {
+			continue
+		}
+
+		err = js.blobs.Store.Put(fileBlobKey(job.ID, i), []byte(contents[i]))
+		if err != nil {
+			break
+		}
+
+		file.ContentB64 = ""
+		file.BlobKey = fileBlobKey(job.ID, i)
+	}
+
+	restore := func() {
+		job.SourceCodeB64 = source
+		for i := range job.Files {
+			job.Files[i].ContentB64 = contents[i]
+		}
+	}
+	if err != nil {
+		js.deleteBlobs(job.projectBlobKeys()...)
+		restore()
+		return nil, err
+	}
+
+	return restore, nil
+}
+
+// LoadSource reads the source and the loaded files of the job from the
+// blob store if they were offloaded.
+func (js *JobServiceImpl) LoadSource(job *Job) error {
+	if job.SourceBlobKey != "" && job.SourceCodeB64 == "" {
+		data, err := js.getBlob(job.SourceBlobKey)
+		if err != nil {
+			return err
+		}
+
+		job.SourceCodeB64 = string(data)
+	}
+
+	for i := range job.Files {
+		file := &job.Files[i]
+		if file.BlobKey == "" || file.ContentB64 != "" {
+			continue
+		}
+
+		data, err := js.getBlob(file.BlobKey)
+		if err != nil {
+			return err
+		}
+
+		file.ContentB64 = string(data)
+	}
+
+	return nil
+}
+
+// ArchiveOutput moves the output of the finished job into the blob store
+// if outputs are archived. Jobs without output are left as they are.
+func (js *JobServiceImpl) ArchiveOutput(jobId string) error {
+	if js.blobs.Store == nil || !js.blobs.ArchiveOutputs {
+		return nil
+	}
+
+	outputs, err := getStoredOutputs(js.db, jobId, 0, -1)
+	if err != nil || len(outputs) == 0 {
+		return err
+	}
+
+	lines := make([]string, len(outputs))
+	for i := range outputs {
+		lines[i] = outputs[i].Text
+	}
+
+	archived := &Job{
+		OutputBlobKey:   outputBlobKey(jobId),
+		OutputLines:     int64(len(lines)),
+		OutputPartLines: archivePartLines,
+	}
+	keys := archived.outputBlobKeys()
+	for i, key := range keys {
+		end := (i + 1) * archivePartLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		data, err := compressLines(lines[i*archivePartLines : end])
+		if err == nil {
+			err = js.blobs.Store.Put(key, data)
+		}
+
+		if err != nil {
+			js.deleteBlobs(keys[:i]...)
+			return err
+		}
+	}
+
+	err = js.db.Transaction(
+		func(tx *gorm.DB) error {
+			err := tx.Model(&Job{}).
+				Where("id = ?", jobId).
+				Select("output_blob_key", "output_lines", "output_part_lines").
+				Updates(archived).Error
+			if err != nil {
+				return err
+			}
+
+			_, err = deleteOutputs(tx, jobId)
+			return err
+		},
+	)
+	if err != nil {
+		js.deleteBlobs(keys...)
+	}
+
+	return err
+}
+
+// ArchiveOutputs archives outputs of finished jobs which are still stored
+// in the database, batchSize jobs at a time, and returns the number of
+// archived jobs.
+func (js *JobServiceImpl) ArchiveOutputs(batchSize int) (int64, error) {
+	var archived int64
+	for {
+		var ids []string
+		err := js.db.Model(&Job{}).
+			Where("status = ? AND output_blob_key = ''", JobStatusFinished).
+			Where(
+				"id IN (?) OR id IN (?)",
+				js.db.Model(&JobOutputRow{}).Select("job_id"),
+				js.db.Model(&JobOutputChunk{}).Select("job_id"),
+			).
+			Limit(batchSize).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return archived, err
+		}
+
+		for _, id := range ids {
+			err = js.ArchiveOutput(id)
+			if err != nil {
+				return archived, err
+			}
+
+			archived++
+		}
+	}
+}
+
+// getArchivedOutputs returns the lines of the archived output from offset
+// as rows whose IDs are one-based line numbers. Only the parts holding the
+// lines are read.
+func (js *JobServiceImpl) getArchivedOutputs(job *Job, offset, limit int) ([]JobOutputRow, error) {
+	if offset < 0 {
+		offset = 0
+	}
+
+	createdAt := job.CreatedAt
+	if job.FinishedAt != nil {
+		createdAt = *job.FinishedAt
+	}
+
+	outputs := []JobOutputRow{}
+	err := js.readArchivedOutput(
+		job, offset, limit, func(firstLine int, lines []string) bool {
+			outputs = appendLineRows(outputs, job.ID, firstLine, lines, offset, limit, createdAt)
+			return true
+		},
+	)
+	return outputs, err
+}
+
+// readArchivedOutput passes the parts of the archived output which hold
+// the lines from offset to read along with the zero-based number of their
+// first line, until read returns false.
+func (js *JobServiceImpl) readArchivedOutput(
+	job *Job, offset, limit int, read func(firstLine int, lines []string) bool,
+) error {
+	keys := job.outputBlobKeys()
+	first := 0
+	if job.OutputPartLines > 0 {
+		if limit == 0 {
+			return nil
+		}
+
+		first = offset / job.OutputPartLines
+		if limit > 0 {
+			last := (offset + limit - 1) / job.OutputPartLines
+			if last+1 < len(keys) {
+				keys = keys[:last+1]
+			}
+		}
+	}
+
+	for i := first; i < len(keys); i++ {
+		data, err := js.getBlob(keys[i])
+		if err != nil {
+			return err
+		}
+
+		lines, err := decompressLines(data)
+		if err != nil {
+			return err
+		}
+
+		if !read(i*job.OutputPartLines, lines) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// countArchivedLines returns the number of lines of the archived output.
+// Only outputs archived as a single blob are read to count them.
+func (js *JobServiceImpl) countArchivedLines(job *Job) (int64, error) {
+	if job.OutputPartLines > 0 {
+		return job.OutputLines, nil
+	}
+
+	data, err := js.getBlob(job.OutputBlobKey)
+	if err != nil {
+		return 0, err
+	}
+
+	lines, err := decompressLines(data)
+	return int64(len(lines)), err
+}
+
+// getBlobKeys returns keys of the blobs of the jobs.
+func (js *JobServiceImpl) getBlobKeys(ids []string) ([]string, error) {
+	if js.blobs.Store == nil {
+		return nil, nil
+	}
+
+	var found []Job
+	err := js.db.Unscoped().
+		Select("source_blob_key", "output_blob_key", "output_lines", "output_part_lines").
+		Find(&found, "id IN ?", ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	err = js.db.Unscoped().Model(&JobFile{}).Where("job_id IN ? AND blob_key <> ''", ids).Pluck("blob_key", &keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range found {
+		keys = append(keys, job.SourceBlobKey)
+		keys = append(keys, job.outputBlobKeys()...)
+	}
+
+	return keys, nil
+}
+
+// deleteBlobs removes the blobs, failures are only logged since an orphaned
+// blob is harmless.
+func (js *JobServiceImpl) deleteBlobs(keys ...string) {
+	for _, key := range keys {
+		if key == "" || js.blobs.Store == nil {
+			continue
+		}
+
+		err := js.blobs.Store.Delete(key)
+		if err != nil {
+			logging.Warningf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+func (js *JobServiceImpl) getBlob(key string) ([]byte, error) {
+	if js.blobs.Store == nil {
+		return nil, errBlobsDisabled
+	}
+
+	return js.blobs.Store.Get(key)
+}
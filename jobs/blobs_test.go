@@ -0,0 +1,170 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"borsch-playground-api/blobs"
+)
+
+// memoryStore keeps blobs in memory and records the keys which were read.
+type memoryStore struct {
+	blobs.BlobStore
+
+	data map[string][]byte
+	read []string
+}
+
+func (s *memoryStore) Put(key string, data []byte) error {
+	s.data[key] = data
+	return nil
+}
+
+func (s *memoryStore) Get(key string) ([]byte, error) {
+	s.read = append(s.read, key)
+	data, ok := s.data[key]
+	if !ok {
+		return nil, blobs.ErrNotFound
+	}
+
+	return data, nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	delete(s.data, key)
+	return nil
+}
+
+func (s *memoryStore) keys() string {
+	var keys []string
+	for key := range s.data {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
+
+func newTestBlobJobService(tb testing.TB) (*JobServiceImpl, *memoryStore) {
+	js, _ := newTestJobService(tb)
+	store := &memoryStore{data: map[string][]byte{}}
+	return js.WithBlobs(BlobConfig{Store: store, SourceThreshold: 10, ArchiveOutputs: true}), store
+}
+
+func TestProjectIsOffloadedAsWhole(t *testing.T) {
+	js, store := newTestBlobJobService(t)
+	job := &Job{Kind: JobKindRun, LangVersion: "0.1.0", Status: JobStatusAccepted, EntryPoint: "main.b"}
+	job.ID = "job"
+	job.Files = []JobFile{{Path: "main.b", ContentB64: "bWFpbg=="}, {Path: "lib.b", ContentB64: "bGli"}}
+	err := js.CreateJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Files[0].ContentB64 != "bWFpbg==" || job.Files[1].ContentB64 != "bGli" {
+		t.Errorf("contents are not restored: %+v", job.Files)
+	}
+
+	if got := store.keys(); got != "files/job/0 files/job/1" {
+		t.Errorf("blobs are %q", got)
+	}
+
+	var contents []string
+	js.db.Model(&JobFile{}).Order("id").Pluck("content_b64", &contents)
+	if strings.Join(contents, "") != "" {
+		t.Errorf("contents %q are stored in the database", contents)
+	}
+
+	loaded, err := js.GetJobWithProject("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loadedContents := map[string]string{}
+	for _, file := range loaded.Files {
+		loadedContents[file.Path] = file.ContentB64
+	}
+
+	if fmt.Sprint(loadedContents) != "map[lib.b:bGli main.b:bWFpbg==]" {
+		t.Errorf("contents are loaded as %v", loadedContents)
+	}
+
+	err = js.DeleteJob("job")
+	if err == nil {
+		_, err = js.CleanUp(RetentionPolicy{}, false)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := store.keys(); got != "" {
+		t.Errorf("blobs %q are left", got)
+	}
+}
+
+func TestArchivedOutputIsReadByParts(t *testing.T) {
+	js, store := newTestBlobJobService(t)
+	createTestJob(t, js, "job", JobStatusFinished)
+	lines := make([]string, 2*archivePartLines+5)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("l%d", i+1)
+	}
+
+	err := js.AppendOutput(outputRows("job", lines...))
+	if err == nil {
+		err = js.ArchiveOutput("job")
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := store.keys(); got != "outputs/job/0 outputs/job/1 outputs/job/2" {
+		t.Errorf("blobs are %q", got)
+	}
+
+	for _, page := range []struct {
+		offset, limit int
+		want, read    string
+	}{
+		{0, 2, "1:l1 2:l2", "outputs/job/0"},
+		{archivePartLines - 1, 2, "10000:l10000 10001:l10001", "outputs/job/0 outputs/job/1"},
+		{2*archivePartLines + 3, -1, "20004:l20004 20005:l20005", "outputs/job/2"},
+		{3 * archivePartLines, 10, "", ""},
+	} {
+		store.read = nil
+		outputs, err := js.GetJobOutputs("job", page.offset, page.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := formatOutputs(outputs); got != page.want {
+			t.Errorf("offset %d, limit %d: got %q, want %q", page.offset, page.limit, got, page.want)
+		}
+
+		if got := strings.Join(store.read, " "); got != page.read {
+			t.Errorf("offset %d, limit %d: read %q, want %q", page.offset, page.limit, got, page.read)
+		}
+	}
+
+	store.read = nil
+	deleted, err := js.DeleteJobOutputs("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != int64(len(lines)) || len(store.read) != 0 || store.keys() != "" {
+		t.Errorf("deleted %d lines reading %v, blobs %q are left", deleted, store.read, store.keys())
+	}
+}
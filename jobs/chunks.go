@@ -0,0 +1,464 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"borsch-playground-api/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobOutputChunk stores LineCount consecutive lines of the job's output,
+// starting from the zero-based FirstLine, as a gzipped JSON array. TextSize
+// is the size of the lines before compression.
+type JobOutputChunk struct {
+	common.Model
+
+	ID        uint `gorm:"primaryKey;autoIncrement"`
+	JobID     string
+	FirstLine int
+	LineCount int
+	TextSize  int64
+	Data      []byte
+}
+
+// CompressionResult reports outputs moved from rows to chunks.
+type CompressionResult struct {
+	Jobs   int64
+	Rows   int64
+	Chunks int64
+}
+
+func newOutputChunk(jobId string, firstLine int, lines []string) (*JobOutputChunk, error) {
+	data, err := compressLines(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for _, line := range lines {
+		size += int64(len(line))
+	}
+
+	return &JobOutputChunk{
+		JobID:     jobId,
+		FirstLine: firstLine,
+		LineCount: len(lines),
+		TextSize:  size,
+		Data:      data,
+	}, nil
+}
+
+func (m *JobOutputChunk) lines() ([]string, error) {
+	return decompressLines(m.Data)
+}
+
+// compressLines encodes lines as a gzipped JSON array.
+func compressLines(lines []string) ([]byte, error) {
+	data, err := json.Marshal(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func decompressLines(data []byte) ([]string, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	return lines, json.Unmarshal(decompressed, &lines)
+}
+
+// splitIntoChunks stores the lines as chunks of up to chunkLines lines.
+func splitIntoChunks(jobId string, firstLine int, lines []string, chunkLines int) ([]*JobOutputChunk, error) {
+	var chunks []*JobOutputChunk
+	for start := 0; start < len(lines); start += chunkLines {
+		end := start + chunkLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		chunk, err := newOutputChunk(jobId, firstLine+start, lines[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+// AppendOutputChunks stores the rows, possibly of several jobs, as chunks
+// of up to chunkLines lines following the lines already stored as rows or
+// chunks. Lines are added to the job's last chunk first while it has room,
+// so that frequent small batches do not pile up as tiny chunks. The job
+// row is locked, so that instances storing lines of the same job at once
+// number them one after another.
+func (js *JobServiceImpl) AppendOutputChunks(rows []JobOutputRow, chunkLines int) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var ids []string
+	lines := map[string][]string{}
+	for _, row := range rows {
+		if _, ok := lines[row.JobID]; !ok {
+			ids = append(ids, row.JobID)
+		}
+
+		lines[row.JobID] = append(lines[row.JobID], row.Text)
+	}
+
+	return js.db.Transaction(
+		func(tx *gorm.DB) error {
+			for _, id := range ids {
+				err := tx.Unscoped().
+					Clauses(clause.Locking{Strength: "UPDATE"}).
+					Select("id").
+					Where("id = ?", id).
+					Limit(1).
+					Find(&Job{}).Error
+				if err != nil {
+					return err
+				}
+
+				next, err := countStoredLines(tx, id)
+				if err != nil {
+					return err
+				}
+
+				filled, err := fillLastChunk(tx, id, int(next), lines[id], chunkLines)
+				if err != nil {
+					return err
+				}
+
+				if filled == len(lines[id]) {
+					continue
+				}
+
+				chunks, err := splitIntoChunks(id, int(next)+filled, lines[id][filled:], chunkLines)
+				if err != nil {
+					return err
+				}
+
+				if err = tx.Create(chunks).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	)
+}
+
+// fillLastChunk adds the first lines to the last chunk of the job if it
+// ends the stored output before line next and has fewer than chunkLines
+// lines, and returns the number of added lines.
+func fillLastChunk(tx *gorm.DB, jobId string, next int, lines []string, chunkLines int) (int, error) {
+	var last JobOutputChunk
+	err := tx.Where("job_id = ?", jobId).Order("first_line DESC").Limit(1).Find(&last).Error
+	if err != nil || last.ID == 0 || last.FirstLine+last.LineCount != next || last.LineCount >= chunkLines {
+		return 0, err
+	}
+
+	stored, err := last.lines()
+	if err != nil {
+		return 0, err
+	}
+
+	take := chunkLines - last.LineCount
+	if take > len(lines) {
+		take = len(lines)
+	}
+
+	filled, err := newOutputChunk(jobId, last.FirstLine, append(stored, lines[:take]...))
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Model(&last).Updates(
+		map[string]interface{}{"line_count": filled.LineCount, "text_size": filled.TextSize, "data": filled.Data},
+	).Error
+	return take, err
+}
+
+// CompactOutput merges the chunks and rows of the finished job into chunks
+// of chunkLines lines, unless they are already as few as possible.
+func (js *JobServiceImpl) CompactOutput(jobId string, chunkLines int) error {
+	return js.db.Transaction(
+		func(tx *gorm.DB) error {
+			_, err := chunkOutput(tx, jobId, chunkLines, false)
+			return err
+		},
+	)
+}
+
+// outputChunking reports the lines of an output rewritten as chunks.
+type outputChunking struct {
+	rows   int64
+	chunks int64
+}
+
+// chunkOutput rewrites the stored output of the job as chunks of
+// chunkLines lines. Unless forced, outputs without rows which are already
+// in as few chunks as possible are left as they are and nil is returned.
+func chunkOutput(tx *gorm.DB, jobId string, chunkLines int, force bool) (*outputChunking, error) {
+	var rows, chunks int64
+	err := tx.Model(&JobOutputRow{}).Where("job_id = ?", jobId).Count(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Model(&JobOutputChunk{}).Where("job_id = ?", jobId).Count(&chunks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := getStoredOutputs(tx, jobId, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if !force && rows == 0 && chunks <= int64((len(outputs)+chunkLines-1)/chunkLines) {
+		return nil, nil
+	}
+
+	lines := make([]string, len(outputs))
+	for i := range outputs {
+		lines[i] = outputs[i].Text
+	}
+
+	compacted, err := splitIntoChunks(jobId, 0, lines, chunkLines)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = deleteOutputs(tx, jobId); err != nil {
+		return nil, err
+	}
+
+	if len(compacted) > 0 {
+		if err = tx.Create(compacted).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &outputChunking{rows: rows, chunks: int64(len(compacted))}, nil
+}
+
+// countStoredLines returns the number of lines of the job stored as rows
+// and chunks.
+func countStoredLines(db *gorm.DB, jobId string) (int64, error) {
+	var rows, chunkedLines int64
+	err := db.Model(&JobOutputRow{}).Where("job_id = ?", jobId).Count(&rows).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.Model(&JobOutputChunk{}).
+		Where("job_id = ?", jobId).
+		Select("COALESCE(SUM(line_count), 0)").
+		Scan(&chunkedLines).Error
+	return rows + chunkedLines, err
+}
+
+// hasOutputChunks reports whether any of the job's output is stored in
+// chunks.
+func hasOutputChunks(db *gorm.DB, jobId string) (bool, error) {
+	var count int64
+	err := db.Model(&JobOutputChunk{}).Where("job_id = ?", jobId).Limit(1).Count(&count).Error
+	return count > 0, err
+}
+
+// getStoredOutputs returns the lines of the job stored in the database from
+// offset as rows whose IDs are one-based line numbers; a negative limit
+// returns all of them.
+//
+// The storage may be switched while a job runs, so an output may consist
+// of rows and chunks alike. Chunks are always stored after the lines
+// stored so far and hold the lines they were numbered with; rows, in the
+// order of their IDs, hold the remaining lines in turn.
+func getStoredOutputs(db *gorm.DB, jobId string, offset, limit int) ([]JobOutputRow, error) {
+	if offset < 0 {
+		offset = 0
+	}
+
+	var ranges []JobOutputChunk
+	err := db.Select("first_line", "line_count").Order("first_line").Find(&ranges, "job_id = ?", jobId).Error
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := []JobOutputRow{}
+	inWindow := func(first, count int) bool {
+		return (count < 0 || first+count > offset) && (limit < 0 || first < offset+limit)
+	}
+
+	// Lines from position on up to the next chunk are rows from rowIndex
+	// on; a negative count stands for the rows after the last chunk.
+	position, rowIndex := 0, 0
+	readRows := func(count int) error {
+		if count == 0 || !inWindow(position, count) {
+			return nil
+		}
+
+		skip := 0
+		if position < offset {
+			skip = offset - position
+		}
+
+		take := -1
+		if count >= 0 {
+			take = count - skip
+		}
+
+		if limit >= 0 && (count < 0 || position+count > offset+limit) {
+			take = offset + limit - position - skip
+		}
+
+		var rows []JobOutputRow
+		err := db.Order("id").Offset(rowIndex+skip).Limit(take).Find(&rows, "job_id = ?", jobId).Error
+		for i := range rows {
+			rows[i].ID = uint(position + skip + i + 1)
+		}
+
+		outputs = append(outputs, rows...)
+		return err
+	}
+
+	chunked := false
+	for _, r := range ranges {
+		if err = readRows(r.FirstLine - position); err != nil {
+			return nil, err
+		}
+
+		rowIndex += r.FirstLine - position
+		position = r.FirstLine + r.LineCount
+		chunked = chunked || inWindow(r.FirstLine, r.LineCount)
+	}
+
+	if err = readRows(-1); err != nil {
+		return nil, err
+	}
+
+	if !chunked {
+		return outputs, nil
+	}
+
+	query := db.Where("job_id = ? AND first_line + line_count > ?", jobId, offset)
+	if limit >= 0 {
+		query = query.Where("first_line < ?", offset+limit)
+	}
+
+	var chunks []JobOutputChunk
+	err = query.Order("first_line").Find(&chunks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range chunks {
+		lines, err := chunks[i].lines()
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = appendLineRows(outputs, jobId, chunks[i].FirstLine, lines, offset, limit, chunks[i].CreatedAt)
+	}
+
+	sort.Slice(
+		outputs, func(i, j int) bool {
+			return outputs[i].ID < outputs[j].ID
+		},
+	)
+	return outputs, nil
+}
+
+// appendLineRows appends the lines which fall within offset and limit as
+// rows whose IDs are one-based line numbers.
+func appendLineRows(
+	outputs []JobOutputRow, jobId string, firstLine int, lines []string, offset, limit int, createdAt time.Time,
+) []JobOutputRow {
+	for i, line := range lines {
+		number := firstLine + i
+		if number < offset || (limit >= 0 && number >= offset+limit) {
+			continue
+		}
+
+		row := JobOutputRow{JobID: jobId, Text: line}
+		row.ID = uint(number + 1)
+		row.CreatedAt = createdAt
+		outputs = append(outputs, row)
+	}
+
+	return outputs
+}
+
+// CompressOutputs moves output rows of finished jobs into chunks of
+// chunkLines lines, merged with the chunks the jobs may already have,
+// batchSize jobs at a time.
+func (js *JobServiceImpl) CompressOutputs(chunkLines, batchSize int) (*CompressionResult, error) {
+	result := &CompressionResult{}
+	for {
+		var ids []string
+		err := js.db.Model(&JobOutputRow{}).
+			Distinct("job_id").
+			Where("job_id IN (?)", js.db.Model(&Job{}).Select("id").Where("status = ?", JobStatusFinished)).
+			Limit(batchSize).
+			Pluck("job_id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return result, err
+		}
+
+		for _, id := range ids {
+			err = js.db.Transaction(
+				func(tx *gorm.DB) error {
+					chunking, err := chunkOutput(tx, id, chunkLines, true)
+					if err != nil {
+						return err
+					}
+
+					result.Jobs++
+					result.Rows += chunking.rows
+					result.Chunks += chunking.chunks
+					return nil
+				},
+			)
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"borsch-playground-api/migrations"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestJobService returns a service on a new SQLite database and the path
+// of its file.
+func newTestJobService(tb testing.TB) (*JobServiceImpl, string) {
+	path := filepath.Join(tb.TempDir(), "test.sqlite")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	err = migrations.Migrate(db)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return NewJobServiceImpl(db), path
+}
+
+func createTestJob(tb testing.TB, js JobService, id string, status JobStatus) {
+	job := &Job{Kind: JobKindRun, LangVersion: "0.1.0", Status: status, Attempts: 1}
+	job.ID = id
+	err := js.CreateJob(job)
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func outputRows(jobId string, texts ...string) []JobOutputRow {
+	rows := make([]JobOutputRow, len(texts))
+	for i, text := range texts {
+		rows[i] = JobOutputRow{JobID: jobId, Text: text}
+	}
+
+	return rows
+}
+
+// storeMixedOutput stores lines 1 to 8 of the job switching between rows
+// and chunks, as if the storage was switched while the job ran.
+func storeMixedOutput(t *testing.T, js *JobServiceImpl, jobId string) {
+	steps := []error{
+		js.AppendOutput(outputRows(jobId, "l1", "l2", "l3")),
+		js.AppendOutputChunks(outputRows(jobId, "l4", "l5"), 1000),
+		js.AppendOutput(outputRows(jobId, "l6", "l7")),
+		js.AppendOutputChunks(outputRows(jobId, "l8"), 1000),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// formatOutputs formats rows as id:text pairs.
+func formatOutputs(rows []JobOutputRow) string {
+	parts := make([]string, len(rows))
+	for i, row := range rows {
+		parts[i] = fmt.Sprintf("%d:%s", row.ID, row.Text)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func checkOutputPages(t *testing.T, js *JobServiceImpl, jobId string) {
+	for _, page := range []struct {
+		offset, limit int
+		want          string
+	}{
+		{0, -1, "1:l1 2:l2 3:l3 4:l4 5:l5 6:l6 7:l7 8:l8"},
+		{2, 3, "3:l3 4:l4 5:l5"},
+		{5, 2, "6:l6 7:l7"},
+		{7, 10, "8:l8"},
+		{8, 10, ""},
+	} {
+		outputs, err := js.GetJobOutputs(jobId, page.offset, page.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := formatOutputs(outputs); got != page.want {
+			t.Errorf("offset %d, limit %d: got %q, want %q", page.offset, page.limit, got, page.want)
+		}
+	}
+}
+
+func TestOutputOfRowsAndChunksReadInOrder(t *testing.T) {
+	js, _ := newTestJobService(t)
+	createTestJob(t, js, "job", JobStatusRunning)
+	storeMixedOutput(t, js, "job")
+	checkOutputPages(t, js, "job")
+
+	result, err := js.SearchOutput("job", &OutputSearch{Query: "l7", Context: 1, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Matches) != 1 || fmt.Sprint(result.Matches[0]) != "{{7 l7} [{6 l6}] [{8 l8}]}" {
+		t.Errorf("unexpected matches %v", result.Matches)
+	}
+}
+
+func TestAppendOutputChunksFillsLastChunk(t *testing.T) {
+	js, _ := newTestJobService(t)
+	createTestJob(t, js, "a", JobStatusRunning)
+	createTestJob(t, js, "b", JobStatusRunning)
+	for _, batch := range [][]JobOutputRow{
+		append(outputRows("a", "a1"), outputRows("b", "b1", "b2", "b3", "b4")...),
+		append(outputRows("a", "a2"), outputRows("b", "b5")...),
+		outputRows("a", "a3", "a4"),
+	} {
+		err := js.AppendOutputChunks(batch, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, want := range map[string]string{"a": "0+3 3+1", "b": "0+3 3+2"} {
+		var chunks []JobOutputChunk
+		js.db.Order("first_line").Find(&chunks, "job_id = ?", id)
+		var got []string
+		for _, chunk := range chunks {
+			got = append(got, fmt.Sprintf("%d+%d", chunk.FirstLine, chunk.LineCount))
+		}
+
+		if strings.Join(got, " ") != want {
+			t.Errorf("job %s is stored in chunks %v, want %s", id, got, want)
+		}
+	}
+
+	outputs, err := js.GetJobOutputs("a", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := formatOutputs(outputs); got != "1:a1 2:a2 3:a3 4:a4" {
+		t.Errorf("output is %q", got)
+	}
+}
+
+func TestCompactOutputMergesRowsAndChunks(t *testing.T) {
+	js, _ := newTestJobService(t)
+	createTestJob(t, js, "job", JobStatusFinished)
+	storeMixedOutput(t, js, "job")
+
+	err := js.CompactOutput("job", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows, chunks int64
+	js.db.Model(&JobOutputRow{}).Count(&rows)
+	js.db.Model(&JobOutputChunk{}).Count(&chunks)
+	if rows != 0 || chunks != 2 {
+		t.Errorf("output is stored in %d rows and %d chunks, want 2 chunks", rows, chunks)
+	}
+
+	checkOutputPages(t, js, "job")
+}
+
+func TestCompressOutputsMergesRowsAndChunks(t *testing.T) {
+	js, _ := newTestJobService(t)
+	createTestJob(t, js, "job", JobStatusFinished)
+	storeMixedOutput(t, js, "job")
+
+	result, err := js.CompressOutputs(1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Jobs != 1 || result.Rows != 5 || result.Chunks != 1 {
+		t.Errorf("unexpected result %+v", *result)
+	}
+
+	checkOutputPages(t, js, "job")
+}
+
+// BenchmarkOutputStorage stores 100k lines of a typical log in batches as
+// the consumer does, as rows and as compacted chunks, and reports the
+// number of rows of the database and the size of its file.
+func BenchmarkOutputStorage(b *testing.B) {
+	const lines = 100000
+	const batchSize = 1000
+	for _, chunked := range []bool{false, true} {
+		name := "rows"
+		if chunked {
+			name = "chunks"
+		}
+
+		b.Run(
+			name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					js, path := newTestJobService(b)
+					createTestJob(b, js, "job", JobStatusRunning)
+					b.StartTimer()
+
+					for start := 0; start < lines; start += batchSize {
+						batch := make([]JobOutputRow, batchSize)
+						for j := range batch {
+							batch[j] = JobOutputRow{
+								JobID: "job",
+								Text:  fmt.Sprintf("Ітерація %d: сума = %d, середнє = %.3f", start+j, (start+j)*7, float64(start+j)/3),
+							}
+						}
+
+						var err error
+						if chunked {
+							err = js.AppendOutputChunks(batch, 1000)
+						} else {
+							err = js.AppendOutput(batch)
+						}
+
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+
+					if chunked {
+						if err := js.CompactOutput("job", 1000); err != nil {
+							b.Fatal(err)
+						}
+					}
+
+					b.StopTimer()
+					var rows, chunks int64
+					js.db.Model(&JobOutputRow{}).Count(&rows)
+					js.db.Model(&JobOutputChunk{}).Count(&chunks)
+					info, err := os.Stat(path)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					b.ReportMetric(float64(rows+chunks), "rows")
+					b.ReportMetric(float64(info.Size())/1e6, "MB")
+				}
+			},
+		)
+	}
+}
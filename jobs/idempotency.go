@@ -0,0 +1,90 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"errors"
+	"time"
+
+	"borsch-playground-api/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyKey stores the response to the first request a client sent
+// with the key; keys of different clients are independent. StatusCode is
+// zero while the request is being processed; such keys expire soon, so
+// that requests interrupted by a crash can be retried.
+type IdempotencyKey struct {
+	common.Model
+
+	ID          uint `gorm:"primaryKey;autoIncrement"`
+	Client      string
+	Key         string `gorm:"column:idempotency_key"`
+	RequestHash string
+	StatusCode  int
+	Response    string
+	ExpiresAt   time.Time
+}
+
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
+
+// ReserveIdempotencyKey stores the key, unless the client has a key with the
+// same value which has not expired yet, in which case the stored key is
+// returned.
+func (js *JobServiceImpl) ReserveIdempotencyKey(key *IdempotencyKey) (*IdempotencyKey, error) {
+	// The second attempt is made after removing an expired key.
+	for i := 0; i < 2; i++ {
+		result := js.db.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			return nil, nil
+		}
+
+		existing := &IdempotencyKey{}
+		err := js.db.First(existing, "client = ? AND idempotency_key = ?", key.Client, key.Key).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if existing.ExpiresAt.After(time.Now()) {
+			return existing, nil
+		}
+
+		err = js.db.Unscoped().Delete(existing).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("failed to reserve idempotency key")
+}
+
+// CompleteIdempotencyKey stores the response of the request and the new
+// expiry of the key.
+func (js *JobServiceImpl) CompleteIdempotencyKey(
+	key *IdempotencyKey, statusCode int, response string, expiresAt time.Time,
+) error {
+	key.StatusCode = statusCode
+	key.Response = response
+	key.ExpiresAt = expiresAt
+	return js.db.Model(key).Select("status_code", "response", "expires_at").Updates(key).Error
+}
+
+// ReleaseIdempotencyKey removes the key, so that the request can be retried.
+func (js *JobServiceImpl) ReleaseIdempotencyKey(key *IdempotencyKey) error {
+	return js.db.Unscoped().Delete(key).Error
+}
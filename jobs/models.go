@@ -9,11 +9,13 @@
 package jobs
 
 import (
-	"fmt"
-	"strings"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
 
 	"borsch-playground-api/common"
-	"github.com/gin-gonic/gin"
 )
 
 type JobOutputRow struct {
@@ -37,18 +39,76 @@ const (
 type Job struct {
 	common.Model
 
-	SourceCodeB64 string         `json:"source_code_b64"`
-	Outputs       []JobOutputRow `json:"-" gorm:"foreignKey:JobID"`
-	ExitCode      *int           `json:"exit_code"`
-	OutputUrl     string         `json:"output_url" gorm:"-:all"`
-	Status        JobStatus      `json:"status"`
+	Kind              JobKind         `json:"kind"`
+	LangVersion       string          `json:"lang_version"`
+	SourceCodeB64     string          `json:"source_code_b64"`
+	SourceBlobKey     string          `json:"-"`
+	EntryPoint        string          `json:"entry_point,omitempty"`
+	Files             []JobFile       `json:"-" gorm:"foreignKey:JobID"`
+	Arguments         []JobArgument   `json:"-" gorm:"foreignKey:JobID"`
+	EnvVars           []JobEnvVar     `json:"-" gorm:"foreignKey:JobID"`
+	Outputs           []JobOutputRow  `json:"-" gorm:"foreignKey:JobID"`
+	OutputBlobKey     string          `json:"-"`
+	OutputLines       int64           `json:"-"`
+	OutputPartLines   int             `json:"-"`
+	TestCases         []JobTestCase   `json:"-" gorm:"foreignKey:JobID"`
+	TestSummary       *TestSummary    `json:"test_summary,omitempty" gorm:"-:all"`
+	ExitCode          *int            `json:"exit_code"`
+	OutputUrl         string          `json:"output_url" gorm:"-:all"`
+	Links             map[string]Link `json:"links,omitempty" gorm:"-:all"`
+	Status            JobStatus       `json:"status"`
+	Priority          int             `json:"priority"`
+	Attempts          int             `json:"attempts"`
+	LastError         string          `json:"last_error,omitempty"`
+	NextRetryAt       *time.Time      `json:"next_retry_at,omitempty"`
+	Queue             *QueueEstimate  `json:"queue,omitempty" gorm:"-:all"`
+	StartedAt         *time.Time      `json:"started_at"`
+	FinishedAt        *time.Time      `json:"finished_at"`
+	DurationMs        *int64          `json:"duration_ms"`
+	CallbackUrl       string          `json:"callback_url,omitempty"`
+	Client            string          `json:"-"`
+	DeletionTokenHash string          `json:"-"`
+	BatchID           *string         `json:"batch_id,omitempty"`
+	CacheKey          string          `json:"-"`
+	Cached            bool            `json:"cached"`
+	CachedFromID      *string         `json:"cached_from,omitempty"`
 }
 
-func (m *Job) GetOutputUrl(c *gin.Context) string {
-	rURI := c.Request.RequestURI
-	if !strings.HasSuffix(rURI, m.ID) {
-		rURI += m.ID
+// Link points to a resource related to the job. Method is set unless the
+// link is to be followed with GET.
+type Link struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+type JobBatch struct {
+	common.Model
+
+	Jobs []Job `json:"-" gorm:"foreignKey:BatchID"`
+}
+
+// NewDeletionToken generates a random token which allows deleting the job
+// and stores its hash.
+func (m *Job) NewDeletionToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
 	}
 
-	return fmt.Sprintf("%s://%s%s/output", "http", c.Request.Host, rURI)
+	token := hex.EncodeToString(bytes)
+	m.DeletionTokenHash = hashToken(token)
+	return token, nil
+}
+
+func (m *Job) CheckDeletionToken(token string) bool {
+	if m.DeletionTokenHash == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(m.DeletionTokenHash)) == 1
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
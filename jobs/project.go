@@ -0,0 +1,79 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"borsch-playground-api/common"
+)
+
+var envVarNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// JobFile is a source file of a multi-file project. Path is relative to the
+// project root and uses forward slashes. The content of a file of a large
+// project is stored in the blob store under BlobKey.
+type JobFile struct {
+	common.Model
+
+	ID         uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	JobID      string `json:"-"`
+	Path       string `json:"path"`
+	ContentB64 string `json:"content_b64"`
+	BlobKey    string `json:"-"`
+}
+
+type JobArgument struct {
+	common.Model
+
+	ID       uint   `json:"-" gorm:"primaryKey;autoIncrement"`
+	JobID    string `json:"-"`
+	Position int    `json:"-"`
+	Value    string `json:"value"`
+}
+
+type JobEnvVar struct {
+	common.Model
+
+	ID    uint   `json:"-" gorm:"primaryKey;autoIncrement"`
+	JobID string `json:"-"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ValidateFilePath rejects absolute paths, paths escaping the project root
+// and paths which are not in the canonical form.
+func ValidateFilePath(p string) error {
+	switch {
+	case p == "" || p == ".":
+		return errors.New("file path is empty")
+	case strings.ContainsAny(p, "\\\x00"):
+		return fmt.Errorf("file path '%s' contains forbidden characters", p)
+	case path.IsAbs(p):
+		return fmt.Errorf("file path '%s' must be relative", p)
+	case path.Clean(p) != p:
+		return fmt.Errorf("file path '%s' is not canonical, use '%s'", p, path.Clean(p))
+	case p == ".." || strings.HasPrefix(p, "../"):
+		return fmt.Errorf("file path '%s' points outside of the project", p)
+	}
+
+	return nil
+}
+
+func ValidateEnvVarName(name string) error {
+	if !envVarNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid environment variable name '%s'", name)
+	}
+
+	return nil
+}
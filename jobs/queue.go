@@ -0,0 +1,101 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import "time"
+
+// recentJobs is the number of the latest finished jobs of a language
+// version used to compute the average duration.
+const recentJobs = 50
+
+// QueueEstimate tells where the queued job is in its queue. The wait and
+// start time are unknown until jobs of the language version have finished.
+type QueueEstimate struct {
+	Position         int64      `json:"position"`
+	EstimatedWaitSec *float64   `json:"estimated_wait_sec"`
+	EstimatedStartAt *time.Time `json:"estimated_start_at"`
+}
+
+type VersionStatusCount struct {
+	LangVersion string
+	Status      JobStatus
+	Count       int64
+}
+
+// MarkStarted sets the start time once, when the first result of the job
+// arrives.
+func (m *Job) MarkStarted(now time.Time) {
+	if m.StartedAt == nil {
+		m.StartedAt = &now
+	}
+}
+
+func (m *Job) MarkFinished(now time.Time) {
+	m.MarkStarted(now)
+	m.FinishedAt = &now
+	duration := now.Sub(*m.StartedAt).Milliseconds()
+	m.DurationMs = &duration
+}
+
+// GetQueuePosition returns the 1-based position of the queued job among
+// queued jobs of the given language versions, which share its queue. Jobs
+// with higher priority and older jobs with the same priority are ahead.
+func (js *JobServiceImpl) GetQueuePosition(job *Job, versions []string) (int64, error) {
+	var ahead int64
+	err := js.db.Model(&Job{}).
+		Where("status = ? AND lang_version IN ? AND id <> ?", JobStatusQueued, versions, job.ID).
+		Where(
+			"priority > ? OR (priority = ? AND created_at < ?)", job.Priority, job.Priority, job.CreatedAt,
+		).
+		Count(&ahead).Error
+	return ahead + 1, err
+}
+
+// GetAverageDurations returns the average duration of the latest finished
+// jobs of every language version.
+func (js *JobServiceImpl) GetAverageDurations() (map[string]time.Duration, error) {
+	recent := js.db.Model(&Job{}).
+		Select(
+			"lang_version, duration_ms, " +
+				"ROW_NUMBER() OVER (PARTITION BY lang_version ORDER BY finished_at DESC) AS recent_rank",
+		).
+		Where("duration_ms IS NOT NULL AND lang_version <> ''")
+
+	var rows []struct {
+		LangVersion string
+		AvgMs       float64
+	}
+	err := js.db.Table("(?) AS recent", recent).
+		Select("lang_version, AVG(duration_ms) AS avg_ms").
+		Where("recent_rank <= ?", recentJobs).
+		Group("lang_version").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	durations := map[string]time.Duration{}
+	for _, row := range rows {
+		durations[row.LangVersion] = time.Duration(row.AvgMs * float64(time.Millisecond))
+	}
+
+	return durations, nil
+}
+
+// GetActiveJobCounts returns the number of queued and running jobs of every
+// language version.
+func (js *JobServiceImpl) GetActiveJobCounts() ([]VersionStatusCount, error) {
+	var counts []VersionStatusCount
+	err := js.db.Model(&Job{}).
+		Select("lang_version, status, COUNT(*) AS count").
+		Where("status IN ?", []JobStatus{JobStatusQueued, JobStatusRunning}).
+		Group("lang_version, status").
+		Scan(&counts).Error
+	return counts, err
+}
@@ -0,0 +1,100 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"time"
+
+	"borsch-playground-api/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ResultCacheEntry points to a finished job whose outputs and exit code are
+// reused by new jobs with the same cache key.
+type ResultCacheEntry struct {
+	common.Model
+
+	ID         uint   `gorm:"primaryKey;autoIncrement"`
+	Key        string `gorm:"column:cache_key"`
+	JobID      string
+	ExitCode   int
+	OutputSize int64
+	Hits       int64
+	ExpiresAt  time.Time
+}
+
+type ResultCachePolicy struct {
+	TTL            time.Duration
+	MaxEntries     int
+	MaxOutputBytes int64
+}
+
+// GetCachedResult returns the entry with the key which has not expired yet
+// and counts the hit.
+func (js *JobServiceImpl) GetCachedResult(key string) (*ResultCacheEntry, error) {
+	entry := &ResultCacheEntry{}
+	err := js.db.First(entry, "cache_key = ? AND expires_at > ?", key, time.Now()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = js.db.Model(entry).UpdateColumn("hits", gorm.Expr("hits + 1")).Error
+	return entry, err
+}
+
+// CacheResult stores the result of the finished job under its cache key,
+// unless its output is too large, and drops the entries over the limit.
+func (js *JobServiceImpl) CacheResult(job *Job, policy ResultCachePolicy) error {
+	if job.CacheKey == "" || job.ExitCode == nil {
+		return nil
+	}
+
+	var size, chunkedSize int64
+	err := js.db.Model(&JobOutputRow{}).
+		Where("job_id = ?", job.ID).
+		Select("COALESCE(SUM(LENGTH(text)), 0)").
+		Scan(&size).Error
+	if err != nil {
+		return err
+	}
+
+	err = js.db.Model(&JobOutputChunk{}).
+		Where("job_id = ?", job.ID).
+		Select("COALESCE(SUM(text_size), 0)").
+		Scan(&chunkedSize).Error
+	if err != nil {
+		return err
+	}
+
+	size += chunkedSize
+	if size > policy.MaxOutputBytes {
+		return nil
+	}
+
+	entry := &ResultCacheEntry{
+		Key:        job.CacheKey,
+		JobID:      job.ID,
+		ExitCode:   *job.ExitCode,
+		OutputSize: size,
+		ExpiresAt:  time.Now().Add(policy.TTL),
+	}
+	err = js.db.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "cache_key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_at", "job_id", "exit_code", "output_size", "expires_at"}),
+		},
+	).Create(entry).Error
+	if err != nil {
+		return err
+	}
+
+	kept := js.db.Unscoped().Model(&ResultCacheEntry{}).Select("id").Order("expires_at DESC").Limit(policy.MaxEntries)
+	return js.db.Unscoped().Where("id NOT IN (?)", kept).Delete(&ResultCacheEntry{}).Error
+}
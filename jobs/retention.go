@@ -0,0 +1,205 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"borsch-playground-api/logging"
+	"gorm.io/gorm"
+)
+
+// RetentionPolicy describes which jobs are removed together with their
+// outputs. Jobs deleted by clients are always removed; MaxAge and
+// MaxJobsPerClient are applied only to jobs in one of Statuses, zero
+// values disable them.
+type RetentionPolicy struct {
+	MaxAge           time.Duration
+	MaxJobsPerClient int
+	Statuses         []JobStatus
+	BatchSize        int
+}
+
+type CleanUpResult struct {
+	Deleted      int64 `json:"deleted"`
+	Expired      int64 `json:"expired"`
+	OverLimit    int64 `json:"over_limit"`
+	OutputRows   int64 `json:"output_rows"`
+	OutputChunks int64 `json:"output_chunks"`
+}
+
+func (r CleanUpResult) Jobs() int64 {
+	return r.Deleted + r.Expired + r.OverLimit
+}
+
+// CleanUp hard-deletes jobs matching the policy and their outputs in
+// batches, then empty batches, expired idempotency keys and cached results.
+// With dryRun set, only the number of jobs is reported.
+func (js *JobServiceImpl) CleanUp(policy RetentionPolicy, dryRun bool) (*CleanUpResult, error) {
+	result := &CleanUpResult{}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 500
+	}
+
+	var err error
+	result.Deleted, err = js.cleanUpBatches(
+		policy.BatchSize, dryRun, result, func(tx *gorm.DB) *gorm.DB {
+			return tx.Unscoped().Model(&Job{}).Where("deleted_at IS NOT NULL")
+		},
+	)
+	if err != nil {
+		return result, err
+	}
+
+	if policy.MaxAge > 0 && len(policy.Statuses) > 0 {
+		before := time.Now().Add(-policy.MaxAge)
+		result.Expired, err = js.cleanUpBatches(
+			policy.BatchSize, dryRun, result, func(tx *gorm.DB) *gorm.DB {
+				return tx.Model(&Job{}).Where("created_at < ? AND status IN ?", before, policy.Statuses)
+			},
+		)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if policy.MaxJobsPerClient > 0 && len(policy.Statuses) > 0 {
+		result.OverLimit, err = js.cleanUpBatches(
+			policy.BatchSize, dryRun, result, func(tx *gorm.DB) *gorm.DB {
+				ranked := tx.Model(&Job{}).
+					Select("id, status, ROW_NUMBER() OVER (PARTITION BY client ORDER BY created_at DESC) AS client_rank").
+					Where("client <> ''")
+				return tx.Table("(?) AS ranked", ranked).
+					Where("client_rank > ? AND status IN ?", policy.MaxJobsPerClient, policy.Statuses)
+			},
+		)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if !dryRun {
+		err = js.db.Unscoped().
+			Where("NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.batch_id = job_batches.id)").
+			Delete(&JobBatch{}).Error
+	}
+
+	if !dryRun && err == nil {
+		err = js.db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{}).Error
+	}
+
+	if !dryRun && err == nil {
+		err = js.db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&ResultCacheEntry{}).Error
+	}
+
+	return result, err
+}
+
+// notReferenced excludes jobs whose results are reused by cached jobs; they
+// are removed after all the cached jobs are.
+const notReferenced = "id NOT IN (SELECT cached_from_id FROM jobs WHERE cached_from_id IS NOT NULL)"
+
+func (js *JobServiceImpl) cleanUpBatches(
+	batchSize int, dryRun bool, result *CleanUpResult, query func(tx *gorm.DB) *gorm.DB,
+) (int64, error) {
+	if dryRun {
+		var count int64
+		err := query(js.db).Where(notReferenced).Count(&count).Error
+		return count, err
+	}
+
+	var total int64
+	for {
+		var ids []string
+		err := query(js.db).Where(notReferenced).Limit(batchSize).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return total, err
+		}
+
+		keys, err := js.getBlobKeys(ids)
+		if err != nil {
+			return total, err
+		}
+
+		err = js.db.Transaction(
+			func(tx *gorm.DB) error {
+				for _, model := range jobDependencies() {
+					err := tx.Unscoped().Where("job_id IN ?", ids).Delete(model).Error
+					if err != nil {
+						return err
+					}
+				}
+
+				outputs := tx.Unscoped().Where("job_id IN ?", ids).Delete(&JobOutputRow{})
+				if outputs.Error != nil {
+					return outputs.Error
+				}
+
+				result.OutputRows += outputs.RowsAffected
+				chunks := tx.Unscoped().Where("job_id IN ?", ids).Delete(&JobOutputChunk{})
+				if chunks.Error != nil {
+					return chunks.Error
+				}
+
+				result.OutputChunks += chunks.RowsAffected
+				return tx.Unscoped().Where("id IN ?", ids).Delete(&Job{}).Error
+			},
+		)
+		if err != nil {
+			return total, err
+		}
+
+		js.deleteBlobs(keys...)
+		total += int64(len(ids))
+		if len(ids) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// jobDependencies returns models, other than outputs, which reference jobs
+// and must be removed before them, in the order of removal.
+func jobDependencies() []interface{} {
+	return []interface{}{
+		&JobTestCase{},
+		&JobEvent{},
+		&JobFile{},
+		&JobArgument{},
+		&JobEnvVar{},
+		&WebhookAttempt{},
+		&WebhookDelivery{},
+		&ResultCacheEntry{},
+	}
+}
+
+// Janitor periodically cleans up jobs according to the policy returned by
+// Policy, which is asked before every run to pick up reloaded settings.
+type Janitor struct {
+	JobService JobService
+	Policy     func() (policy RetentionPolicy, interval time.Duration)
+}
+
+func (j *Janitor) Run(ctx context.Context) {
+	for {
+		policy, interval := j.Policy()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		result, err := j.JobService.CleanUp(policy, false)
+		if err != nil {
+			logging.Errorf("job cleanup failed: %v", err)
+		} else if result.Jobs() > 0 {
+			logging.Infof("job cleanup removed %d job(s) and %d output row(s)", result.Jobs(), result.OutputRows)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetryPolicy limits how many times a job is published and how long a
+// rejected job waits for its next attempt.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Interval       time.Duration
+	BatchSize      int
+}
+
+// Backoff returns the delay before the next attempt after the given number
+// of failed ones.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	return exponentialBackoff(p.InitialBackoff, p.MaxBackoff, attempts)
+}
+
+// exponentialBackoff doubles the initial delay after every failed attempt
+// up to max.
+func exponentialBackoff(initial, max time.Duration, attempts int) time.Duration {
+	backoff := initial
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+
+	if backoff > max {
+		return max
+	}
+
+	return backoff
+}
+
+// NextRetryAt returns the time of the next attempt, nil if the job has been
+// published the maximum number of times.
+func (p RetryPolicy) NextRetryAt(attempts int, now time.Time) *time.Time {
+	if attempts >= p.MaxAttempts {
+		return nil
+	}
+
+	next := now.Add(p.Backoff(attempts))
+	return &next
+}
+
+// ScheduleRetry records the error which rejected the job and schedules its
+// retry if attempts are left.
+func (m *Job) ScheduleRetry(err error, policy RetryPolicy, now time.Time) {
+	m.LastError = err.Error()
+	m.NextRetryAt = policy.NextRetryAt(m.Attempts, now)
+}
+
+// Settled reports whether the job is finished or rejected without a retry
+// scheduled, so that it does not change anymore by itself.
+func (m *Job) Settled() bool {
+	return m.Status == JobStatusFinished || (m.Status == JobStatusRejected && m.NextRetryAt == nil)
+}
+
+// GetDueRetries returns IDs of rejected jobs whose retry is due, the oldest
+// first.
+func (js *JobServiceImpl) GetDueRetries(now time.Time, limit int) ([]string, error) {
+	var ids []string
+	err := js.db.Model(&Job{}).
+		Where("status = ? AND next_retry_at <= ?", JobStatusRejected, now).
+		Order("next_retry_at").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ClaimRejectedJob moves the rejected job back to accepted and counts its
+// next attempt, so that only one caller publishes it again. If dueBy is
+// set, the job is claimed only if its retry is due by then. It returns
+// false if the job was not claimed.
+func (js *JobServiceImpl) ClaimRejectedJob(job *Job, reason string, dueBy *time.Time) (bool, error) {
+	var claimed bool
+	err := js.db.Transaction(
+		func(tx *gorm.DB) error {
+			query := tx.Model(&Job{}).Where("id = ? AND status = ?", job.ID, JobStatusRejected)
+			if dueBy != nil {
+				query = query.Where("next_retry_at <= ?", *dueBy)
+			}
+
+			// The attempt is counted before the job is published again.
+			result := query.Updates(
+				map[string]interface{}{
+					"status":        JobStatusAccepted,
+					"next_retry_at": nil,
+					"attempts":      gorm.Expr("attempts + 1"),
+				},
+			)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			claimed = true
+			return tx.Create(&JobEvent{JobID: job.ID, From: JobStatusRejected, To: JobStatusAccepted, Reason: reason}).Error
+		},
+	)
+	if claimed {
+		job.Status = JobStatusAccepted
+		job.NextRetryAt = nil
+		job.Attempts++
+	}
+
+	return claimed, err
+}
@@ -0,0 +1,387 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// searchPageSize is the number of rows or lines read at a time while the
+// output is searched.
+const searchPageSize = 1000
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+// OutputSearch finds up to Limit lines of an output which contain Query or,
+// with Regex, match it, and returns them with Context lines before and
+// after each of them.
+type OutputSearch struct {
+	Query         string
+	Regex         bool
+	CaseSensitive bool
+	Context       int
+	Limit         int
+}
+
+// OutputLine is a line of an output with its one-based number.
+type OutputLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+type OutputMatch struct {
+	OutputLine
+
+	Before []OutputLine `json:"before"`
+	After  []OutputLine `json:"after"`
+}
+
+// OutputSearchResult holds the matches in the order of lines. Truncated
+// reports that there are more of them than the limit.
+type OutputSearchResult struct {
+	Matches   []OutputMatch `json:"matches"`
+	Truncated bool          `json:"truncated"`
+}
+
+func (s *OutputSearch) pattern() string {
+	if s.CaseSensitive {
+		return s.Query
+	}
+
+	return "(?i)" + s.Query
+}
+
+func (s *OutputSearch) matcher() (func(string) bool, error) {
+	if s.Regex {
+		// The query is checked on its own so that errors quote it as it
+		// was given.
+		_, err := syntax.Parse(s.Query, syntax.Perl)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+		}
+
+		return regexp.MustCompile(s.pattern()).MatchString, nil
+	}
+
+	if s.CaseSensitive {
+		return func(text string) bool { return strings.Contains(text, s.Query) }, nil
+	}
+
+	query := strings.ToLower(s.Query)
+	return func(text string) bool { return strings.Contains(strings.ToLower(text), query) }, nil
+}
+
+// requiredLiteral returns text which every matching line contains, and
+// whether it is to be matched ignoring case. It returns an empty string if
+// there is no such text, e.g. for alternations.
+func (s *OutputSearch) requiredLiteral() (string, bool) {
+	if !s.Regex {
+		return s.Query, !s.CaseSensitive
+	}
+
+	re, err := syntax.Parse(s.pattern(), syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+
+	parts := []*syntax.Regexp{re}
+	if re = re.Simplify(); re.Op == syntax.OpConcat {
+		parts = re.Sub
+	}
+
+	literal, fold := "", false
+	for _, part := range parts {
+		if part.Op == syntax.OpLiteral && len(string(part.Rune)) > len(literal) {
+			literal, fold = string(part.Rune), part.Flags&syntax.FoldCase != 0
+		}
+	}
+
+	return literal, fold
+}
+
+// filterOutputRows narrows the query of output rows to the rows which
+// contain the literal, in a way the database can use an index for. Where
+// the database cannot compare the literal the way the search does, i.e.
+// ignoring case of non-ASCII text in SQLite, rows are left for the search
+// to check.
+func (js *JobServiceImpl) filterOutputRows(query *gorm.DB, literal string, fold bool) *gorm.DB {
+	if literal == "" {
+		return query
+	}
+
+	pattern := "%" + escapeLike(literal) + "%"
+	switch js.db.Dialector.Name() {
+	case "postgres":
+		if fold {
+			return query.Where(`text ILIKE ? ESCAPE '\'`, pattern)
+		}
+
+		return query.Where(`text LIKE ? ESCAPE '\'`, pattern)
+	case "sqlite":
+		if !fold {
+			return query.Where("instr(text, ?) > 0", literal)
+		}
+
+		// LIKE of SQLite ignores case of ASCII letters only.
+		if isASCII(literal) {
+			return query.Where(`text LIKE ? ESCAPE '\'`, pattern)
+		}
+	}
+
+	return query
+}
+
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+func isASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SearchOutput searches the output of the job. Outputs stored as rows only
+// are filtered by the database; outputs with chunks and archived outputs
+// are read and searched line by line.
+func (js *JobServiceImpl) SearchOutput(jobId string, search *OutputSearch) (*OutputSearchResult, error) {
+	match, err := search.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := js.GetOutputJob(jobId)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := newOutputScanner(search, match)
+	if job.OutputArchived() {
+		err = js.readArchivedOutput(
+			job, 0, -1, func(firstLine int, lines []string) bool {
+				return scanner.addRows(appendLineRows(nil, job.ID, firstLine, lines, 0, -1, job.CreatedAt))
+			},
+		)
+		return scanner.result, err
+	}
+
+	chunked, err := hasOutputChunks(js.db, job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !chunked {
+		return js.searchOutputRows(job.ID, search, match)
+	}
+
+	for offset := 0; ; offset += searchPageSize {
+		outputs, err := getStoredOutputs(js.db, job.ID, offset, searchPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if !scanner.addRows(outputs) || len(outputs) < searchPageSize {
+			return scanner.result, nil
+		}
+	}
+}
+
+// searchOutputRows finds matching rows among the rows the database filter
+// leaves, and then numbers them and reads their context by the position of
+// rows in the output.
+func (js *JobServiceImpl) searchOutputRows(
+	jobId string, search *OutputSearch, match func(string) bool,
+) (*OutputSearchResult, error) {
+	literal, fold := search.requiredLiteral()
+	var ids []uint
+	var lastId uint
+	for len(ids) <= search.Limit {
+		var rows []JobOutputRow
+		query := js.db.Select("id", "text").Where("job_id = ? AND id > ?", jobId, lastId)
+		err := js.filterOutputRows(query, literal, fold).Order("id").Limit(searchPageSize).Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < len(rows) && len(ids) <= search.Limit; i++ {
+			if match(rows[i].Text) {
+				ids = append(ids, rows[i].ID)
+			}
+		}
+
+		if len(rows) < searchPageSize {
+			break
+		}
+
+		lastId = rows[len(rows)-1].ID
+	}
+
+	result := &OutputSearchResult{Matches: []OutputMatch{}}
+	if len(ids) > search.Limit {
+		ids = ids[:search.Limit]
+		result.Truncated = true
+	}
+
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	numbered := js.db.Model(&JobOutputRow{}).
+		Select("id, text, ROW_NUMBER() OVER (ORDER BY id) AS line").
+		Where("job_id = ?", jobId)
+
+	var matches []OutputLine
+	err := js.db.Table("(?) AS numbered", numbered).
+		Select("line, text").
+		Where("id IN ?", ids).
+		Order("line").
+		Scan(&matches).Error
+	if err != nil || search.Context == 0 {
+		for _, line := range matches {
+			result.Matches = append(result.Matches, newOutputMatch(line))
+		}
+
+		return result, err
+	}
+
+	var ranges []string
+	var args []interface{}
+	end := 0
+	for _, line := range matches {
+		first := line.Line - search.Context
+		if first <= end {
+			first = end + 1
+		}
+
+		last := line.Line + search.Context
+		if first <= last {
+			ranges = append(ranges, "line BETWEEN ? AND ?")
+			args = append(args, first, last)
+			end = last
+		}
+	}
+
+	var lines []OutputLine
+	err = js.db.Table("(?) AS numbered", numbered).
+		Select("line, text").
+		Where(strings.Join(ranges, " OR "), args...).
+		Scan(&lines).Error
+	if err != nil {
+		return nil, err
+	}
+
+	texts := map[int]string{}
+	for _, line := range lines {
+		texts[line.Line] = line.Text
+	}
+
+	for _, line := range matches {
+		m := newOutputMatch(line)
+		for number := line.Line - search.Context; number <= line.Line+search.Context; number++ {
+			text, ok := texts[number]
+			if !ok || number == line.Line {
+				continue
+			}
+
+			if number < line.Line {
+				m.Before = append(m.Before, OutputLine{Line: number, Text: text})
+			} else {
+				m.After = append(m.After, OutputLine{Line: number, Text: text})
+			}
+		}
+
+		result.Matches = append(result.Matches, m)
+	}
+
+	return result, nil
+}
+
+func newOutputMatch(line OutputLine) OutputMatch {
+	return OutputMatch{OutputLine: line, Before: []OutputLine{}, After: []OutputLine{}}
+}
+
+// outputScanner searches lines read in order, keeping the last lines for
+// the context before a match and completing the context after matches as
+// further lines are added.
+type outputScanner struct {
+	search *OutputSearch
+	match  func(string) bool
+	result *OutputSearchResult
+
+	before []OutputLine
+
+	// pending are indexes of matches whose context after them is not
+	// complete yet.
+	pending []int
+}
+
+func newOutputScanner(search *OutputSearch, match func(string) bool) *outputScanner {
+	return &outputScanner{
+		search: search,
+		match:  match,
+		result: &OutputSearchResult{Matches: []OutputMatch{}},
+	}
+}
+
+// addRows adds rows whose IDs are line numbers and returns false once the
+// search is complete.
+func (s *outputScanner) addRows(rows []JobOutputRow) bool {
+	for i := range rows {
+		if !s.add(OutputLine{Line: int(rows[i].ID), Text: rows[i].Text}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *outputScanner) add(line OutputLine) bool {
+	pending := s.pending[:0]
+	for _, i := range s.pending {
+		m := &s.result.Matches[i]
+		m.After = append(m.After, line)
+		if len(m.After) < s.search.Context {
+			pending = append(pending, i)
+		}
+	}
+
+	s.pending = pending
+	if !s.result.Truncated && s.match(line.Text) {
+		if len(s.result.Matches) == s.search.Limit {
+			s.result.Truncated = true
+		} else {
+			m := newOutputMatch(line)
+			m.Before = append(m.Before, s.before...)
+			s.result.Matches = append(s.result.Matches, m)
+			if s.search.Context > 0 {
+				s.pending = append(s.pending, len(s.result.Matches)-1)
+			}
+		}
+	}
+
+	if s.search.Context > 0 {
+		s.before = append(s.before, line)
+		if len(s.before) > s.search.Context {
+			s.before = s.before[1:]
+		}
+	}
+
+	return !s.result.Truncated || len(s.pending) > 0
+}
@@ -0,0 +1,99 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"borsch-playground-api/migrations"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// benchPostgresDSN names the variable with the DSN of a PostgreSQL database
+// the benchmarks may migrate and write to.
+const benchPostgresDSN = "BORSCH_BENCH_POSTGRES_DSN"
+
+// BenchmarkOutputIngestionPostgres inserts 100k lines of a typical log in
+// batches as the consumer does, without and with the trigram index of
+// output search, and reports the rate of inserted lines.
+func BenchmarkOutputIngestionPostgres(b *testing.B) {
+	dsn := os.Getenv(benchPostgresDSN)
+	if dsn == "" {
+		b.Skip(benchPostgresDSN + " is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if err = migrations.Migrate(db); err != nil {
+		b.Fatal(err)
+	}
+
+	js := NewJobServiceImpl(db)
+	defer func() {
+		_ = migrations.DropOutputSearchIndex(db)
+	}()
+
+	const lines = 100000
+	const batchSize = 200
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			err = migrations.CreateOutputSearchIndex(db)
+		} else {
+			err = migrations.DropOutputSearchIndex(db)
+		}
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(
+			fmt.Sprintf("trigram_index=%v", indexed), func(b *testing.B) {
+				var elapsed time.Duration
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					id := uuid.New().String()
+					createTestJob(b, js, id, JobStatusRunning)
+					b.StartTimer()
+					started := time.Now()
+
+					for start := 0; start < lines; start += batchSize {
+						batch := make([]JobOutputRow, batchSize)
+						for j := range batch {
+							batch[j] = JobOutputRow{
+								JobID: id,
+								Text:  fmt.Sprintf("Ітерація %d: сума = %d, середнє = %.3f", start+j, (start+j)*7, float64(start+j)/3),
+							}
+						}
+
+						if err := js.AppendOutput(batch); err != nil {
+							b.Fatal(err)
+						}
+					}
+
+					elapsed += time.Since(started)
+					b.StopTimer()
+					if _, err := deleteOutputs(db, id); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.ReportMetric(float64(lines*b.N)/elapsed.Seconds(), "lines/s")
+			},
+		)
+	}
+}
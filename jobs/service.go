@@ -8,17 +8,64 @@
 
 package jobs
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type JobService interface {
 	GetJob(id string) (*Job, error)
 	CreateJob(job *Job) error
-	UpdateJob(job *Job) error
+	GetJobs(ids []string) ([]Job, error)
+	AppendOutput(rows []JobOutputRow) error
+	AppendOutputChunks(rows []JobOutputRow, chunkLines int) error
+	LoadSource(job *Job) error
+	ArchiveOutput(jobId string) error
+	ArchiveOutputs(batchSize int) (int64, error)
+	CompactOutput(jobId string, chunkLines int) error
+	CompressOutputs(chunkLines, batchSize int) (*CompressionResult, error)
+	TransitionJob(job *Job, to JobStatus, reason string, columns ...string) error
+	GetJobEvents(jobId string) ([]JobEvent, error)
+	DeleteJob(id string) error
+	CreateBatch(batch *JobBatch, jobs []*Job) error
+	GetBatch(id string) (*JobBatch, error)
+	GetOutputJob(jobId string) (*Job, error)
 	GetJobOutputs(jobId string, offset, limit int) ([]JobOutputRow, error)
+	SearchOutput(jobId string, search *OutputSearch) (*OutputSearchResult, error)
+	GetTestCases(jobId string) ([]JobTestCase, error)
+	GetTestCase(jobId string, index int) (*JobTestCase, error)
+	UpdateTestCase(testCase *JobTestCase) error
+	CleanUp(policy RetentionPolicy, dryRun bool) (*CleanUpResult, error)
+	CreateWebhookDelivery(delivery *WebhookDelivery) error
+	GetWebhookDeliveries(jobId string) ([]WebhookDelivery, error)
+	ClaimDueWebhookDeliveries(limit int, lease time.Time) ([]WebhookDelivery, error)
+	RecordWebhookAttempt(delivery *WebhookDelivery, attempt *WebhookAttempt) error
+	ReserveIdempotencyKey(key *IdempotencyKey) (*IdempotencyKey, error)
+	CompleteIdempotencyKey(key *IdempotencyKey, statusCode int, response string, expiresAt time.Time) error
+	ReleaseIdempotencyKey(key *IdempotencyKey) error
+	GetCachedResult(key string) (*ResultCacheEntry, error)
+	CacheResult(job *Job, policy ResultCachePolicy) error
+	GetQueuePosition(job *Job, versions []string) (int64, error)
+	GetAverageDurations() (map[string]time.Duration, error)
+	GetActiveJobCounts() ([]VersionStatusCount, error)
+	GetMaintenanceMode() (*MaintenanceMode, error)
+	SetMaintenanceMode(mode *MaintenanceMode) error
+	ListJobs(filter JobFilter, offset, limit int) ([]Job, int64, error)
+	GetJobWithProject(id string) (*Job, error)
+	ResetJob(job *Job) error
+	DeleteJobOutputs(jobId string) (int64, error)
+	GetDueRetries(now time.Time, limit int) ([]string, error)
+	ClaimRejectedJob(job *Job, reason string, dueBy *time.Time) (bool, error)
 }
 
+// outputInsertBatchSize keeps inserts of outputs within the limit of SQLite
+// on the number of query parameters.
+const outputInsertBatchSize = 100
+
 type JobServiceImpl struct {
-	db *gorm.DB
+	db    *gorm.DB
+	blobs BlobConfig
 }
 
 func NewJobServiceImpl(db *gorm.DB) *JobServiceImpl {
@@ -30,21 +77,160 @@ func (js *JobServiceImpl) GetJob(id string) (*Job, error) {
 	return job, js.db.First(job, "ID = ?", id).Error
 }
 
+// CreateJob creates the job with its project and test cases and records
+// its first status. A large project is stored in the blob store.
 func (js *JobServiceImpl) CreateJob(job *Job) error {
-	return js.db.Create(job).Error
+	restore, err := js.offloadProject(job)
+	if err != nil {
+		return err
+	}
+
+	defer restore()
+	err = js.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Create(job).Error; err != nil {
+				return err
+			}
+
+			return tx.Create(creationEvent(job)).Error
+		},
+	)
+	if err != nil {
+		js.deleteBlobs(job.projectBlobKeys()...)
+	}
+
+	return err
 }
 
-func (js *JobServiceImpl) UpdateJob(job *Job) error {
-	return js.db.Save(&job).Error
+// GetJobs returns the jobs with the given IDs without their associations,
+// missing jobs are skipped.
+func (js *JobServiceImpl) GetJobs(ids []string) ([]Job, error) {
+	var found []Job
+	return found, js.db.Find(&found, "id IN ?", ids).Error
 }
 
+// AppendOutput inserts lines of outputs, possibly of several jobs, without
+// touching the jobs themselves. Rows are inserted in order, so that their
+// IDs keep the order of the lines.
+func (js *JobServiceImpl) AppendOutput(rows []JobOutputRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return js.db.CreateInBatches(rows, outputInsertBatchSize).Error
+}
+
+// DeleteJob soft-deletes the job, so it is hidden from clients right away,
+// and removes its entry from the result cache, so that its result is not
+// reused by new jobs. The job and its outputs are removed by the next
+// clean-up.
+func (js *JobServiceImpl) DeleteJob(id string) error {
+	return js.db.Transaction(
+		func(tx *gorm.DB) error {
+			err := tx.Delete(&Job{}, "id = ?", id).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Unscoped().Where("job_id = ?", id).Delete(&ResultCacheEntry{}).Error
+		},
+	)
+}
+
+// CreateBatch creates the batch and all its jobs in one transaction and
+// records their first status. Large projects are stored in the blob store.
+func (js *JobServiceImpl) CreateBatch(batch *JobBatch, jobs []*Job) error {
+	keys := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		restore, err := js.offloadProject(job)
+		if err != nil {
+			js.deleteBlobs(keys...)
+			return err
+		}
+
+		defer restore()
+		keys = append(keys, job.projectBlobKeys()...)
+	}
+
+	err := js.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Create(batch).Error; err != nil {
+				return err
+			}
+
+			events := make([]*JobEvent, len(jobs))
+			for i, job := range jobs {
+				job.BatchID = &batch.ID
+				events[i] = creationEvent(job)
+			}
+
+			if err := tx.CreateInBatches(jobs, 100).Error; err != nil {
+				return err
+			}
+
+			return tx.CreateInBatches(events, 100).Error
+		},
+	)
+	if err != nil {
+		js.deleteBlobs(keys...)
+	}
+
+	return err
+}
+
+// GetBatch returns the batch with its jobs, which have only ID, status,
+// exit code and the time of the next retry loaded.
+func (js *JobServiceImpl) GetBatch(id string) (*JobBatch, error) {
+	batch := &JobBatch{}
+	err := js.db.Preload(
+		"Jobs", func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id", "created_at", "batch_id", "status", "exit_code", "next_retry_at").Order("created_at, id")
+		},
+	).First(batch, "id = ?", id).Error
+	return batch, err
+}
+
+// GetJobOutputs returns outputs of the job or, for a cached job, of the job
+// whose result was reused, from rows, chunks or the blob store.
 func (js *JobServiceImpl) GetJobOutputs(jobId string, offset, limit int) ([]JobOutputRow, error) {
-	_, err := js.GetJob(jobId)
+	job, err := js.GetOutputJob(jobId)
 	if err != nil {
 		return nil, err
 	}
 
-	var outputs []JobOutputRow
-	err = js.db.Offset(offset).Limit(limit).Find(&outputs, "job_id = ?", jobId).Error
-	return outputs, err
+	if job.OutputArchived() {
+		return js.getArchivedOutputs(job, offset, limit)
+	}
+
+	return getStoredOutputs(js.db, job.ID, offset, limit)
+}
+
+// GetOutputJob returns the job whose output is shown for the job, which is
+// the reused job for cached results.
+func (js *JobServiceImpl) GetOutputJob(jobId string) (*Job, error) {
+	job, err := js.GetJob(jobId)
+	if err != nil || job.CachedFromID == nil {
+		return job, err
+	}
+
+	// The reused job may be deleted, but it is kept until the cached jobs
+	// are removed.
+	sourceId := *job.CachedFromID
+	job = &Job{}
+	return job, js.db.Unscoped().First(job, "id = ?", sourceId).Error
+}
+
+func (js *JobServiceImpl) GetTestCases(jobId string) ([]JobTestCase, error) {
+	var testCases []JobTestCase
+	err := js.db.Order("case_index").Find(&testCases, "job_id = ?", jobId).Error
+	return testCases, err
+}
+
+func (js *JobServiceImpl) GetTestCase(jobId string, index int) (*JobTestCase, error) {
+	testCase := &JobTestCase{}
+	return testCase, js.db.First(testCase, "job_id = ? AND case_index = ?", jobId, index).Error
+}
+
+func (js *JobServiceImpl) UpdateTestCase(testCase *JobTestCase) error {
+	return js.db.Save(testCase).Error
 }
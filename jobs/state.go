@@ -0,0 +1,141 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"fmt"
+
+	"borsch-playground-api/common"
+	"borsch-playground-api/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxTransitionAttempts bounds how many times a transition is retried after
+// the status was changed concurrently.
+const maxTransitionAttempts = 3
+
+// jobTransitions lists the statuses a job may move to from each status. A
+// job is accepted again when it is retried or requeued before it runs, it
+// may start running before it is marked as queued, and it may be finished
+// by an operator or from the cache at any time. Finished jobs never change.
+var jobTransitions = map[JobStatus][]JobStatus{
+	JobStatusAccepted: {JobStatusAccepted, JobStatusQueued, JobStatusRejected, JobStatusRunning, JobStatusFinished},
+	JobStatusRejected: {JobStatusAccepted, JobStatusFinished},
+	JobStatusQueued:   {JobStatusAccepted, JobStatusRunning, JobStatusRejected, JobStatusFinished},
+	JobStatusRunning:  {JobStatusRunning, JobStatusRejected, JobStatusFinished},
+	JobStatusFinished: {},
+}
+
+// JobEvent records a change of the job's status. From is empty for the
+// event of creation.
+type JobEvent struct {
+	common.Model
+
+	ID     uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	JobID  string    `json:"-"`
+	From   JobStatus `json:"from" gorm:"column:from_status"`
+	To     JobStatus `json:"to" gorm:"column:to_status"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+type TransitionError struct {
+	JobID string
+	From  JobStatus
+	To    JobStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("job %s can not change status from %s to %s", e.JobID, e.From, e.To)
+}
+
+func CanTransition(from, to JobStatus) bool {
+	for _, status := range jobTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TransitionJob moves the job to the status and updates the given columns
+// from the job. The update is conditional on the status the job was read
+// with, so that a concurrent change is not overwritten; if the status has
+// changed, it is read again and the transition is checked from the new
+// status. An illegal transition returns TransitionError and changes
+// nothing.
+func (js *JobServiceImpl) TransitionJob(job *Job, to JobStatus, reason string, columns ...string) error {
+	from := job.Status
+	for attempt := 0; attempt < maxTransitionAttempts; attempt++ {
+		if !CanTransition(from, to) {
+			err := &TransitionError{JobID: job.ID, From: from, To: to}
+			logging.Warningf("%v", err)
+			return err
+		}
+
+		values := *job
+		values.Status = to
+		var updated bool
+		err := js.db.Transaction(
+			func(tx *gorm.DB) error {
+				result := tx.Model(&Job{}).
+					Where("id = ? AND status = ?", job.ID, from).
+					Select(append([]string{"status"}, columns...)).
+					Omit(clause.Associations).
+					Updates(&values)
+				if result.Error != nil || result.RowsAffected == 0 {
+					return result.Error
+				}
+
+				updated = true
+				if from == to {
+					return nil
+				}
+
+				return tx.Create(&JobEvent{JobID: job.ID, From: from, To: to, Reason: reason}).Error
+			},
+		)
+		if err != nil {
+			return err
+		}
+
+		if updated {
+			job.Status = to
+			return nil
+		}
+
+		current := &Job{}
+		err = js.db.Select("status").First(current, "id = ?", job.ID).Error
+		if err != nil {
+			return err
+		}
+
+		from = current.Status
+	}
+
+	return fmt.Errorf("status of job %s keeps changing", job.ID)
+}
+
+// GetJobEvents returns the status history of the job, the oldest first.
+func (js *JobServiceImpl) GetJobEvents(jobId string) ([]JobEvent, error) {
+	var events []JobEvent
+	err := js.db.Order("id").Find(&events, "job_id = ?", jobId).Error
+	return events, err
+}
+
+// creationEvent returns the first event of the new job.
+func creationEvent(job *Job) *JobEvent {
+	reason := "created"
+	if job.CachedFromID != nil {
+		reason = "result reused from job " + *job.CachedFromID
+	}
+
+	return &JobEvent{JobID: job.ID, To: job.Status, Reason: reason}
+}
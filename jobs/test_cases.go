@@ -0,0 +1,150 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"borsch-playground-api/common"
+)
+
+type JobKind string
+
+const (
+	JobKindRun  JobKind = "run"
+	JobKindTest JobKind = "test"
+)
+
+type CompareMode string
+
+const (
+	CompareModeExact            CompareMode = "exact"
+	CompareModeTrimmed          CompareMode = "trimmed"
+	CompareModeRegex            CompareMode = "regex"
+	CompareModeIgnoreWhitespace CompareMode = "ignore_whitespace"
+)
+
+var compareModes = []CompareMode{
+	CompareModeExact,
+	CompareModeTrimmed,
+	CompareModeRegex,
+	CompareModeIgnoreWhitespace,
+}
+
+// JobTestCase is a single run of the job's program with the given stdin.
+// Actual values and the verdict are set when the worker reports the result.
+type JobTestCase struct {
+	common.Model
+
+	ID               uint        `json:"id" gorm:"primaryKey;autoIncrement"`
+	JobID            string      `json:"-"`
+	Index            int         `json:"index" gorm:"column:case_index"`
+	Stdin            string      `json:"stdin"`
+	ExpectedStdout   string      `json:"expected_stdout"`
+	ExpectedExitCode *int        `json:"expected_exit_code"`
+	CompareMode      CompareMode `json:"compare_mode"`
+	ActualStdout     *string     `json:"actual_stdout"`
+	ActualExitCode   *int        `json:"actual_exit_code"`
+	Passed           *bool       `json:"passed"`
+}
+
+type TestSummary struct {
+	Total   int `json:"total"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Pending int `json:"pending"`
+}
+
+// ValidateCompareMode checks the mode and, for the regex mode, the
+// expected output.
+func ValidateCompareMode(mode CompareMode, expected string) error {
+	found := false
+	for _, m := range compareModes {
+		found = found || m == mode
+	}
+
+	if !found {
+		names := make([]string, len(compareModes))
+		for i, m := range compareModes {
+			names[i] = string(m)
+		}
+
+		return fmt.Errorf("invalid compare mode, available values are '%s'", strings.Join(names, "', '"))
+	}
+
+	if mode == CompareModeRegex {
+		if _, err := regexp.Compile(expected); err != nil {
+			return fmt.Errorf("invalid expected output regex: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Evaluate records the actual result of the test case and decides whether
+// it passed.
+func (tc *JobTestCase) Evaluate(stdout string, exitCode int) {
+	tc.ActualStdout = &stdout
+	tc.ActualExitCode = &exitCode
+	passed := compareOutput(tc.CompareMode, tc.ExpectedStdout, stdout)
+	if tc.ExpectedExitCode != nil {
+		passed = passed && *tc.ExpectedExitCode == exitCode
+	}
+
+	tc.Passed = &passed
+}
+
+func Summarize(testCases []JobTestCase) *TestSummary {
+	summary := &TestSummary{Total: len(testCases)}
+	for _, tc := range testCases {
+		switch {
+		case tc.Passed == nil:
+			summary.Pending++
+		case *tc.Passed:
+			summary.Passed++
+		default:
+			summary.Failed++
+		}
+	}
+
+	return summary
+}
+
+func compareOutput(mode CompareMode, expected, actual string) bool {
+	switch mode {
+	case CompareModeTrimmed:
+		return trimLines(expected) == trimLines(actual)
+	case CompareModeRegex:
+		re, err := compileExpectedRegex(expected)
+		return err == nil && re.MatchString(strings.TrimSuffix(actual, "\n"))
+	case CompareModeIgnoreWhitespace:
+		return strings.Join(strings.Fields(expected), " ") == strings.Join(strings.Fields(actual), " ")
+	default:
+		return expected == actual
+	}
+}
+
+// trimLines removes leading and trailing spaces of every line and leading
+// and trailing empty lines.
+func trimLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// compileExpectedRegex compiles the expected output which must match the
+// whole actual output.
+func compileExpectedRegex(expected string) (*regexp.Regexp, error) {
+	return regexp.Compile(`\A(?:` + expected + `)\z`)
+}
@@ -0,0 +1,183 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"borsch-playground-api/logging"
+)
+
+const (
+	WebhookSignatureHeader = "X-Borsch-Signature"
+	WebhookTimestampHeader = "X-Borsch-Timestamp"
+	WebhookEventHeader     = "X-Borsch-Event"
+	WebhookDeliveryHeader  = "X-Borsch-Delivery"
+)
+
+type WebhookConfig struct {
+	Secret         string
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+	Interval       time.Duration
+	BatchSize      int
+}
+
+// Backoff returns the delay before the next attempt after the given number
+// of failed ones.
+func (c WebhookConfig) Backoff(attempts int) time.Duration {
+	return exponentialBackoff(c.InitialBackoff, c.MaxBackoff, attempts)
+}
+
+// SignWebhook returns the value of the signature header: a hex encoded
+// HMAC-SHA256 of the timestamp and the body joined with a dot.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher periodically sends due webhook deliveries. Config is
+// asked before every run to pick up reloaded settings; nothing is sent
+// while the secret is empty.
+type WebhookDispatcher struct {
+	JobService JobService
+	Config     func() WebhookConfig
+	Client     *http.Client
+}
+
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	if d.Client == nil {
+		d.Client = NewWebhookClient()
+	}
+
+	for {
+		config := d.Config()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.Interval):
+		}
+
+		if config.Secret != "" {
+			d.dispatch(ctx, config)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context, config WebhookConfig) {
+	// Deliveries are sent concurrently, so the lease must only outlive a
+	// single request.
+	deliveries, err := d.JobService.ClaimDueWebhookDeliveries(config.BatchSize, time.Now().Add(2*config.Timeout))
+	if err != nil {
+		logging.Errorf("failed to claim webhook deliveries: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		wg.Add(1)
+		go func(delivery *WebhookDelivery) {
+			defer wg.Done()
+			d.deliver(ctx, config, delivery)
+		}(&deliveries[i])
+	}
+
+	wg.Wait()
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, config WebhookConfig, delivery *WebhookDelivery) {
+	delivery.Attempts++
+	attempt := &WebhookAttempt{Number: delivery.Attempts}
+	start := time.Now()
+	statusCode, err := d.send(ctx, config, delivery)
+	now := time.Now()
+	attempt.DurationMs = now.Sub(start).Milliseconds()
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = WebhookDeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= config.MaxAttempts:
+		attempt.Error = attemptError(statusCode)
+		delivery.Status = WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		logging.Warningf("webhook delivery %d of job %s failed: %v", delivery.ID, delivery.JobID, err)
+	default:
+		attempt.Error = attemptError(statusCode)
+		next := now.Add(config.Backoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		logging.Debugf("webhook delivery %d of job %s will be retried: %v", delivery.ID, delivery.JobID, err)
+	}
+
+	err = d.JobService.RecordWebhookAttempt(delivery, attempt)
+	if err != nil {
+		logging.Errorf("failed to save webhook attempt: %v", err)
+	}
+}
+
+// attemptError describes a failed attempt to clients. Details, such as
+// errors of connections or messages of the receiver, would let clients
+// probe networks by the server, so they are only logged.
+func attemptError(statusCode int) string {
+	if statusCode != 0 {
+		return "unexpected response status"
+	}
+
+	return "request failed"
+}
+
+// send posts the payload and returns the status code of the response, zero
+// if there is none. Only 2xx responses are successful.
+func (d *WebhookDispatcher) send(ctx context.Context, config WebhookConfig, delivery *WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "borsch-playground-api")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(config.Secret, timestamp, body))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,267 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookJobService records attempts of deliveries; other methods of the
+// service are not used by the dispatcher.
+type webhookJobService struct {
+	JobService
+
+	attempts []WebhookAttempt
+}
+
+func (s *webhookJobService) RecordWebhookAttempt(_ *WebhookDelivery, attempt *WebhookAttempt) error {
+	s.attempts = append(s.attempts, *attempt)
+	return nil
+}
+
+func testWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Secret:         "test-secret",
+		MaxAttempts:    3,
+		InitialBackoff: time.Minute,
+		MaxBackoff:     3 * time.Minute,
+		Timeout:        5 * time.Second,
+	}
+}
+
+func testWebhookDelivery() *WebhookDelivery {
+	return &WebhookDelivery{
+		ID:      7,
+		JobID:   "job",
+		Event:   WebhookEventJobFinished,
+		Payload: `{"event":"job.finished","job_id":"job"}`,
+		Status:  WebhookDeliveryPending,
+	}
+}
+
+// newWebhookReceiver starts a receiver which answers with the given status
+// codes in turn, the last one for all further requests, and checks the
+// signature of every request.
+func newWebhookReceiver(t *testing.T, secret string, statusCodes ...int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&requests, 1))
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("failed to read body: %v", err)
+				}
+
+				timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+				if err != nil {
+					t.Errorf("invalid timestamp header: %v", err)
+				}
+
+				if got, want := r.Header.Get(WebhookSignatureHeader), SignWebhook(secret, timestamp, body); got != want {
+					t.Errorf("signature is %q, want %q", got, want)
+				}
+
+				if got := r.Header.Get(WebhookEventHeader); got != WebhookEventJobFinished {
+					t.Errorf("event header is %q", got)
+				}
+
+				if got := r.Header.Get(WebhookDeliveryHeader); got != "7" {
+					t.Errorf("delivery header is %q", got)
+				}
+
+				if n > len(statusCodes) {
+					n = len(statusCodes)
+				}
+
+				w.WriteHeader(statusCodes[n-1])
+			},
+		),
+	)
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestSignWebhook(t *testing.T) {
+	got := SignWebhook("secret", 1666000000, []byte(`{"a":1}`))
+	want := "sha256=83db3fb4c07471bf3979f8e8e601bc44657b9c4291af85225cd8ef2429018ca4"
+	if got != want {
+		t.Fatalf("signature is %q, want %q", got, want)
+	}
+
+	if got == SignWebhook("secret", 1666000001, []byte(`{"a":1}`)) {
+		t.Error("signature does not depend on the timestamp")
+	}
+
+	if got == SignWebhook("other", 1666000000, []byte(`{"a":1}`)) {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestWebhookDelivered(t *testing.T) {
+	config := testWebhookConfig()
+	server, requests := newWebhookReceiver(t, config.Secret, http.StatusNoContent)
+	service := &webhookJobService{}
+	d := &WebhookDispatcher{JobService: service, Client: server.Client()}
+
+	delivery := testWebhookDelivery()
+	delivery.Url = server.URL + "/hook"
+	d.deliver(context.Background(), config, delivery)
+
+	if atomic.LoadInt32(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", atomic.LoadInt32(requests))
+	}
+
+	if delivery.Status != WebhookDeliveryDelivered || delivery.DeliveredAt == nil || delivery.NextAttemptAt != nil {
+		t.Errorf("delivery is %s, delivered at %v, next attempt at %v", delivery.Status, delivery.DeliveredAt, delivery.NextAttemptAt)
+	}
+
+	attempt := service.attempts[0]
+	if attempt.Number != 1 || attempt.StatusCode == nil || *attempt.StatusCode != http.StatusNoContent || attempt.Error != "" {
+		t.Errorf("unexpected attempt %+v", attempt)
+	}
+}
+
+func TestWebhookRetriedWithBackoff(t *testing.T) {
+	config := testWebhookConfig()
+	server, requests := newWebhookReceiver(
+		t, config.Secret, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK,
+	)
+	service := &webhookJobService{}
+	d := &WebhookDispatcher{JobService: service, Client: server.Client()}
+
+	delivery := testWebhookDelivery()
+	delivery.Url = server.URL
+	for attempt, backoff := range []time.Duration{time.Minute, 2 * time.Minute} {
+		before := time.Now()
+		d.deliver(context.Background(), config, delivery)
+		if delivery.Status != WebhookDeliveryPending || delivery.NextAttemptAt == nil {
+			t.Fatalf("attempt %d: delivery is %s, next attempt at %v", attempt+1, delivery.Status, delivery.NextAttemptAt)
+		}
+
+		if next := *delivery.NextAttemptAt; next.Before(before.Add(backoff)) || next.After(time.Now().Add(backoff)) {
+			t.Errorf("attempt %d: next attempt is in %v, want %v", attempt+1, next.Sub(before), backoff)
+		}
+	}
+
+	d.deliver(context.Background(), config, delivery)
+	if atomic.LoadInt32(requests) != 3 || delivery.Status != WebhookDeliveryDelivered || delivery.Attempts != 3 {
+		t.Fatalf("delivery is %s after %d attempts and %d requests", delivery.Status, delivery.Attempts, atomic.LoadInt32(requests))
+	}
+
+	for i, attempt := range service.attempts[:2] {
+		if attempt.Number != i+1 || attempt.StatusCode == nil || attempt.Error != "unexpected response status" {
+			t.Errorf("unexpected attempt %+v", attempt)
+		}
+	}
+}
+
+func TestWebhookFailsAfterMaxAttempts(t *testing.T) {
+	config := testWebhookConfig()
+	config.MaxAttempts = 2
+	server, requests := newWebhookReceiver(t, config.Secret, http.StatusServiceUnavailable)
+	service := &webhookJobService{}
+	d := &WebhookDispatcher{JobService: service, Client: server.Client()}
+
+	delivery := testWebhookDelivery()
+	delivery.Url = server.URL
+	d.deliver(context.Background(), config, delivery)
+	d.deliver(context.Background(), config, delivery)
+
+	if atomic.LoadInt32(requests) != 2 || delivery.Status != WebhookDeliveryFailed || delivery.NextAttemptAt != nil {
+		t.Errorf("delivery is %s with next attempt at %v after %d requests", delivery.Status, delivery.NextAttemptAt, atomic.LoadInt32(requests))
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	config := testWebhookConfig()
+	for attempts, want := range map[int]time.Duration{
+		1: time.Minute,
+		2: 2 * time.Minute,
+		3: 3 * time.Minute,
+		9: 3 * time.Minute,
+	} {
+		if got := config.Backoff(attempts); got != want {
+			t.Errorf("backoff after %d attempts is %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestWebhookClientRefusesNonPublicAddresses(t *testing.T) {
+	config := testWebhookConfig()
+	server, requests := newWebhookReceiver(t, config.Secret, http.StatusOK)
+	service := &webhookJobService{}
+	d := &WebhookDispatcher{JobService: service, Client: NewWebhookClient()}
+
+	delivery := testWebhookDelivery()
+	delivery.Url = server.URL
+	d.deliver(context.Background(), config, delivery)
+
+	if atomic.LoadInt32(requests) != 0 {
+		t.Fatalf("receiver on a loopback address got %d requests", atomic.LoadInt32(requests))
+	}
+
+	attempt := service.attempts[0]
+	if attempt.StatusCode != nil || attempt.Error != "request failed" {
+		t.Errorf("attempt is %+v, want a generic error", attempt)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for address, want := range map[string]bool{
+		"8.8.8.8":                true,
+		"2606:4700:4700::1111":   true,
+		"127.0.0.1":              false,
+		"::1":                    false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false,
+		"fe80::1":                false,
+		"fd00::1":                false,
+		"0.0.0.0":                false,
+		"100.64.0.1":             false,
+		"224.0.0.1":              false,
+		"255.255.255.255":        false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:169.254.169.254": false,
+	} {
+		if got := IsPublicIP(net.ParseIP(address)); got != want {
+			t.Errorf("IsPublicIP(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	for host, allowed := range map[string]bool{
+		"93.184.216.34":   true,
+		"127.0.0.1":       false,
+		"169.254.169.254": false,
+		"::1":             false,
+		"fe80::1%eth0":    false,
+		"localhost":       false,
+		"api.localhost.":  false,
+		"example.com":     true,
+	} {
+		err := CheckWebhookHost(host)
+		if allowed && err != nil {
+			t.Errorf("host %s is refused: %v", host, err)
+		} else if !allowed && err == nil {
+			t.Errorf("host %s is allowed", host)
+		}
+	}
+}
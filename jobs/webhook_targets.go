@@ -0,0 +1,149 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrWebhookTargetNotAllowed is returned for callbacks to hosts which are,
+// or resolve to, addresses that are not public, so that callbacks cannot
+// reach services of the internal network or cloud metadata endpoints.
+var ErrWebhookTargetNotAllowed = errors.New("callback URL must point to a public address")
+
+// nonPublicNetworks are special-purpose ranges which the methods of net.IP
+// do not cover.
+var nonPublicNetworks = mustParseNetworks(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"100::/64",
+	"2001:db8::/32",
+)
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks[i] = network
+	}
+
+	return networks
+}
+
+// IsPublicIP reports whether the address is a globally routable unicast
+// one, i.e. not a loopback, private, link-local, multicast or otherwise
+// reserved address. IPv4-mapped IPv6 addresses are checked as IPv4.
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckWebhookHost returns ErrWebhookTargetNotAllowed if the host of a
+// callback URL is an address which is not public or a name of the local
+// host. Other names are not resolved here, which would hold up requests
+// creating jobs; the dialer of webhooks checks the addresses they resolve
+// to when it connects.
+func CheckWebhookHost(host string) error {
+	if ip := parseIP(host); ip != nil {
+		if !IsPublicIP(ip) {
+			return ErrWebhookTargetNotAllowed
+		}
+
+		return nil
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if name == "localhost" || strings.HasSuffix(name, ".localhost") {
+		return ErrWebhookTargetNotAllowed
+	}
+
+	return nil
+}
+
+// parseIP parses an address which may have an IPv6 zone.
+func parseIP(host string) net.IP {
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+
+	return net.ParseIP(host)
+}
+
+// checkDialedAddress is the control function of the dialer of webhooks. It
+// sees the address a connection is made to after the host is resolved, so
+// a host which resolves to another address than when its URL was
+// validated is refused as well.
+func checkDialedAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := parseIP(host); ip == nil || !IsPublicIP(ip) {
+		return ErrWebhookTargetNotAllowed
+	}
+
+	return nil
+}
+
+// NewWebhookClient returns the client webhooks are sent with. It connects
+// to public addresses only, bypasses proxies of the environment, which
+// would hide the address from the check, and does not follow redirects.
+func NewWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkDialedAddress,
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package jobs
+
+import (
+	"encoding/json"
+	"time"
+
+	"borsch-playground-api/common"
+	"gorm.io/gorm"
+)
+
+const WebhookEventJobFinished = "job.finished"
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is a callback which is sent to the job's callback URL
+// until it succeeds or runs out of attempts.
+type WebhookDelivery struct {
+	common.Model
+
+	ID            uint                  `json:"id" gorm:"primaryKey;autoIncrement"`
+	JobID         string                `json:"job_id"`
+	Url           string                `json:"url"`
+	Event         string                `json:"event"`
+	Payload       string                `json:"-"`
+	Status        WebhookDeliveryStatus `json:"status"`
+	Attempts      int                   `json:"attempts"`
+	NextAttemptAt *time.Time            `json:"next_attempt_at"`
+	DeliveredAt   *time.Time            `json:"delivered_at"`
+	AttemptLog    []WebhookAttempt      `json:"attempt_log" gorm:"foreignKey:DeliveryID"`
+}
+
+// WebhookAttempt records a single request of the delivery. StatusCode is
+// nil when no response was received.
+type WebhookAttempt struct {
+	common.Model
+
+	ID         uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	DeliveryID uint   `json:"-"`
+	JobID      string `json:"-"`
+	Number     int    `json:"number" gorm:"column:attempt_number"`
+	StatusCode *int   `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type JobWebhookPayload struct {
+	Event       string       `json:"event"`
+	JobID       string       `json:"job_id"`
+	Kind        JobKind      `json:"kind"`
+	Status      JobStatus    `json:"status"`
+	ExitCode    *int         `json:"exit_code"`
+	TestSummary *TestSummary `json:"test_summary,omitempty"`
+	FinishedAt  time.Time    `json:"finished_at"`
+}
+
+// NewWebhookDelivery creates a delivery of the finished job's summary to
+// its callback URL, which is due right away.
+func NewWebhookDelivery(job *Job) (*WebhookDelivery, error) {
+	now := time.Now()
+	payload, err := json.Marshal(
+		JobWebhookPayload{
+			Event:       WebhookEventJobFinished,
+			JobID:       job.ID,
+			Kind:        job.Kind,
+			Status:      job.Status,
+			ExitCode:    job.ExitCode,
+			TestSummary: job.TestSummary,
+			FinishedAt:  now,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &WebhookDelivery{
+		JobID:         job.ID,
+		Url:           job.CallbackUrl,
+		Event:         WebhookEventJobFinished,
+		Payload:       string(payload),
+		Status:        WebhookDeliveryPending,
+		NextAttemptAt: &now,
+	}
+	return delivery, nil
+}
+
+func (js *JobServiceImpl) CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	return js.db.Create(delivery).Error
+}
+
+// GetWebhookDeliveries returns deliveries of the job with their attempts.
+func (js *JobServiceImpl) GetWebhookDeliveries(jobId string) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := js.db.Preload(
+		"AttemptLog", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("attempt_number")
+		},
+	).Order("id").Find(&deliveries, "job_id = ?", jobId).Error
+	return deliveries, err
+}
+
+// ClaimDueWebhookDeliveries returns at most limit pending deliveries which
+// are due and postpones each of them until lease, so that other instances
+// do not send them at the same time.
+func (js *JobServiceImpl) ClaimDueWebhookDeliveries(limit int, lease time.Time) ([]WebhookDelivery, error) {
+	var due []WebhookDelivery
+	err := js.db.Where("status = ? AND next_attempt_at <= ?", WebhookDeliveryPending, time.Now()).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []WebhookDelivery
+	for _, delivery := range due {
+		result := js.db.Model(&WebhookDelivery{}).
+			Where("id = ? AND next_attempt_at = ?", delivery.ID, delivery.NextAttemptAt).
+			Update("next_attempt_at", lease)
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			delivery.NextAttemptAt = &lease
+			claimed = append(claimed, delivery)
+		}
+	}
+
+	return claimed, nil
+}
+
+// RecordWebhookAttempt saves the delivery together with its attempt.
+func (js *JobServiceImpl) RecordWebhookAttempt(delivery *WebhookDelivery, attempt *WebhookAttempt) error {
+	return js.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Omit("AttemptLog").Save(delivery).Error; err != nil {
+				return err
+			}
+
+			attempt.DeliveryID = delivery.ID
+			attempt.JobID = delivery.JobID
+			return tx.Create(attempt).Error
+		},
+	)
+}
@@ -0,0 +1,81 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug:   "debug",
+	LevelInfo:    "info",
+	LevelWarning: "warning",
+	LevelError:   "error",
+}
+
+var currentLevel = int32(LevelInfo)
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf(
+		"invalid log level, available values are '%s', '%s', '%s', '%s'",
+		LevelDebug, LevelInfo, LevelWarning, LevelError,
+	)
+}
+
+// SetLevel changes the minimum level of printed messages. It is safe to
+// call concurrently with logging.
+func SetLevel(level Level) {
+	atomic.StoreInt32(&currentLevel, int32(level))
+}
+
+func Debugf(format string, args ...interface{}) {
+	printf(LevelDebug, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	printf(LevelInfo, format, args...)
+}
+
+func Warningf(format string, args ...interface{}) {
+	printf(LevelWarning, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	printf(LevelError, format, args...)
+}
+
+func printf(level Level, format string, args ...interface{}) {
+	if int32(level) < atomic.LoadInt32(&currentLevel) {
+		return
+	}
+
+	log.Printf("["+strings.ToUpper(level.String())+"] "+format, args...)
+}
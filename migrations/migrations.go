@@ -9,18 +9,281 @@
 package migrations
 
 import (
-	"borsch-playground-api/jobs"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+//go:embed postgres/*.sql sqlite/*.sql
+var migrationFiles embed.FS
+
+var (
+	ErrOutdatedSchema = errors.New("database schema is outdated, run 'migrate up'")
+
+	fileNameRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	nameRegex     = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrate applies all pending migrations.
 func Migrate(db *gorm.DB) error {
-	if err := db.AutoMigrate(&jobs.JobOutputRow{}); err != nil {
-		return err
+	_, err := Up(db)
+	return err
+}
+
+// Up applies all pending migrations in order and returns the applied ones.
+func Up(db *gorm.DB) ([]Migration, error) {
+	all, applied, err := load(db)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := db.AutoMigrate(&jobs.Job{}); err != nil {
+	var result []Migration
+	for _, m := range all {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err = db.Transaction(
+			func(tx *gorm.DB) error {
+				if err := tx.Exec(m.Up).Error; err != nil {
+					return err
+				}
+
+				return tx.Create(&schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+			},
+		)
+		if err != nil {
+			return result, fmt.Errorf("migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// Down rolls back the last n applied migrations and returns the reverted ones.
+func Down(db *gorm.DB, n int) ([]Migration, error) {
+	all, applied, err := load(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Migration
+	for i := len(all) - 1; i >= 0 && len(result) < n; i-- {
+		m := all[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		err = db.Transaction(
+			func(tx *gorm.DB) error {
+				if err := tx.Exec(m.Down).Error; err != nil {
+					return err
+				}
+
+				return tx.Delete(&schemaMigration{}, m.Version).Error
+			},
+		)
+		if err != nil {
+			return result, fmt.Errorf("migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// Status returns every known migration along with the time it was applied,
+// if it was.
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	all, applied, err := load(db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, len(all))
+	for i, m := range all {
+		result[i].Migration = m
+		if sm, ok := applied[m.Version]; ok {
+			appliedAt := sm.AppliedAt
+			result[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return result, nil
+}
+
+// CheckUpToDate returns ErrOutdatedSchema if there are pending migrations.
+func CheckUpToDate(db *gorm.DB) error {
+	statuses, err := Status(db)
+	if err != nil {
 		return err
 	}
 
+	pending := 0
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%w: %d pending migration(s)", ErrOutdatedSchema, pending)
+	}
+
 	return nil
 }
+
+// Create writes empty up and down files of a new migration for every
+// supported dialect into dir and returns their paths.
+func Create(dir, name string) ([]string, error) {
+	name = strings.Trim(nameRegex.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, errors.New("migration name is empty")
+	}
+
+	var version uint
+	for _, dialect := range dialects() {
+		entries, err := os.ReadDir(filepath.Join(dir, dialect))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			match := fileNameRegex.FindStringSubmatch(entry.Name())
+			if match == nil {
+				continue
+			}
+
+			v, err := strconv.ParseUint(match[1], 10, 32)
+			if err == nil && uint(v) > version {
+				version = uint(v)
+			}
+		}
+	}
+
+	version++
+	var files []string
+	for _, dialect := range dialects() {
+		for _, direction := range []string{"up", "down"} {
+			file := filepath.Join(dir, dialect, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+			err := os.WriteFile(file, []byte{}, 0644)
+			if err != nil {
+				return files, err
+			}
+
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
+
+func dialects() []string {
+	return []string{"postgres", "sqlite"}
+}
+
+func load(db *gorm.DB) ([]Migration, map[uint]schemaMigration, error) {
+	all, err := readMigrations(db.Dialector.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, nil, err
+	}
+
+	var rows []schemaMigration
+	if err = db.Find(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+
+	applied := map[uint]schemaMigration{}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return all, applied, nil
+}
+
+func readMigrations(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported database dialect: %s", dialect)
+	}
+
+	byVersion := map[uint]*Migration{}
+	for _, entry := range entries {
+		match := fileNameRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dialect, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[m.Version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("conflicting names for migration %04d: %s, %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		result = append(result, *m)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
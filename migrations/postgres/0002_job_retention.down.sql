@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_jobs_client_created_at;
+DROP INDEX IF EXISTS idx_jobs_created_at;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS deletion_token_hash;
+ALTER TABLE jobs DROP COLUMN IF EXISTS client;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS job_batches
+(
+    id         TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_batches_deleted_at ON job_batches (deleted_at);
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS batch_id TEXT REFERENCES job_batches (id);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_batch_id ON jobs (batch_id);
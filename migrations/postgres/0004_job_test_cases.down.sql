@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS job_test_cases;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,21 @@
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'run';
+
+CREATE TABLE IF NOT EXISTS job_test_cases
+(
+    id                 BIGSERIAL PRIMARY KEY,
+    created_at         TIMESTAMPTZ,
+    updated_at         TIMESTAMPTZ,
+    deleted_at         TIMESTAMPTZ,
+    job_id             TEXT    NOT NULL REFERENCES jobs (id),
+    case_index         INTEGER NOT NULL,
+    stdin              TEXT    NOT NULL DEFAULT '',
+    expected_stdout    TEXT    NOT NULL DEFAULT '',
+    expected_exit_code BIGINT,
+    compare_mode       TEXT    NOT NULL DEFAULT 'exact',
+    actual_stdout      TEXT,
+    actual_exit_code   BIGINT,
+    passed             BOOLEAN
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_test_cases_deleted_at ON job_test_cases (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_test_cases_job_id_case_index ON job_test_cases (job_id, case_index);
@@ -0,0 +1,43 @@
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS entry_point TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS job_files
+(
+    id          BIGSERIAL PRIMARY KEY,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ,
+    deleted_at  TIMESTAMPTZ,
+    job_id      TEXT NOT NULL REFERENCES jobs (id),
+    path        TEXT NOT NULL,
+    content_b64 TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_files_deleted_at ON job_files (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_files_job_id_path ON job_files (job_id, path);
+
+CREATE TABLE IF NOT EXISTS job_arguments
+(
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    job_id     TEXT    NOT NULL REFERENCES jobs (id),
+    position   INTEGER NOT NULL,
+    value      TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_arguments_deleted_at ON job_arguments (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_arguments_job_id_position ON job_arguments (job_id, position);
+
+CREATE TABLE IF NOT EXISTS job_env_vars
+(
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    job_id     TEXT NOT NULL REFERENCES jobs (id),
+    name       TEXT NOT NULL,
+    value      TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_env_vars_deleted_at ON job_env_vars (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_env_vars_job_id_name ON job_env_vars (job_id, name);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS result_cache_entries;
+
+DROP INDEX IF EXISTS idx_jobs_cached_from_id;
+ALTER TABLE jobs DROP COLUMN IF EXISTS cached_from_id;
+ALTER TABLE jobs DROP COLUMN IF EXISTS cached;
+ALTER TABLE jobs DROP COLUMN IF EXISTS cache_key;
@@ -0,0 +1,24 @@
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cache_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cached BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cached_from_id TEXT REFERENCES jobs (id);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_cached_from_id ON jobs (cached_from_id);
+
+CREATE TABLE IF NOT EXISTS result_cache_entries
+(
+    id          BIGSERIAL PRIMARY KEY,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ,
+    deleted_at  TIMESTAMPTZ,
+    cache_key   TEXT        NOT NULL,
+    job_id      TEXT        NOT NULL REFERENCES jobs (id),
+    exit_code   BIGINT      NOT NULL,
+    output_size BIGINT      NOT NULL DEFAULT 0,
+    hits        BIGINT      NOT NULL DEFAULT 0,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_result_cache_entries_deleted_at ON result_cache_entries (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_result_cache_entries_cache_key ON result_cache_entries (cache_key);
+CREATE INDEX IF NOT EXISTS idx_result_cache_entries_job_id ON result_cache_entries (job_id);
+CREATE INDEX IF NOT EXISTS idx_result_cache_entries_expires_at ON result_cache_entries (expires_at);
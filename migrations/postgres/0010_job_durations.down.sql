@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_jobs_lang_version_finished_at;
+DROP INDEX IF EXISTS idx_jobs_status_lang_version;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS duration_ms;
+ALTER TABLE jobs DROP COLUMN IF EXISTS finished_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS started_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS lang_version;
@@ -0,0 +1,7 @@
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS lang_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS finished_at TIMESTAMPTZ;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status_lang_version ON jobs (status, lang_version);
+CREATE INDEX IF NOT EXISTS idx_jobs_lang_version_finished_at ON jobs (lang_version, finished_at);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS maintenance_modes
+(
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    enabled    BOOLEAN NOT NULL DEFAULT FALSE,
+    message    TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_maintenance_modes_deleted_at ON maintenance_modes (deleted_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_jobs_status_next_retry_at;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS next_retry_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS last_error;
+ALTER TABLE jobs DROP COLUMN IF EXISTS attempts;
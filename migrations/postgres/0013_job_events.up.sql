@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS job_events
+(
+    id          BIGSERIAL PRIMARY KEY,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ,
+    deleted_at  TIMESTAMPTZ,
+    job_id      TEXT NOT NULL REFERENCES jobs (id),
+    from_status TEXT NOT NULL DEFAULT '',
+    to_status   TEXT NOT NULL,
+    reason      TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_events_deleted_at ON job_events (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_job_events_job_id ON job_events (job_id);
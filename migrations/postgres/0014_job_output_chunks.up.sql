@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS job_output_chunks
+(
+    id         BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    job_id     TEXT    NOT NULL REFERENCES jobs (id),
+    first_line INTEGER NOT NULL,
+    line_count INTEGER NOT NULL,
+    text_size  INTEGER NOT NULL,
+    data       BYTEA   NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_output_chunks_deleted_at ON job_output_chunks (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_output_chunks_job_id_first_line ON job_output_chunks (job_id, first_line);
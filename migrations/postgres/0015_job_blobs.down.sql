@@ -0,0 +1,2 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS output_blob_key;
+ALTER TABLE jobs DROP COLUMN IF EXISTS source_blob_key;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_job_output_rows_text_trgm;
+DROP INDEX IF EXISTS idx_job_output_rows_job_id_id;
@@ -0,0 +1,6 @@
+DELETE FROM idempotency_keys;
+
+DROP INDEX IF EXISTS idx_idempotency_keys_client_idempotency_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_idempotency_key ON idempotency_keys (idempotency_key);
+
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS client;
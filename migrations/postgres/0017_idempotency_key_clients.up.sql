@@ -0,0 +1,9 @@
+-- Keys are scoped by the client which sent them. Existing keys are not
+-- bound to a client and their responses hold deletion tokens, so they are
+-- dropped.
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS client TEXT NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS idx_idempotency_keys_idempotency_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_client_idempotency_key ON idempotency_keys (client, idempotency_key);
@@ -0,0 +1,3 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS output_part_lines;
+ALTER TABLE jobs DROP COLUMN IF EXISTS output_lines;
+ALTER TABLE job_files DROP COLUMN IF EXISTS blob_key;
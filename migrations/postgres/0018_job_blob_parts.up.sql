@@ -0,0 +1,3 @@
+ALTER TABLE job_files ADD COLUMN IF NOT EXISTS blob_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS output_lines BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS output_part_lines INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,73 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package migrations
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// outputSearchIndex is the name of the trigram index of output rows.
+const outputSearchIndex = "idx_job_output_rows_text_trgm"
+
+var ErrSearchIndexUnsupported = errors.New("the output search index is only supported on PostgreSQL")
+
+// CreateOutputSearchIndex creates the trigram index which lets PostgreSQL
+// narrow down searches of outputs stored as rows. It is not a migration,
+// since the pg_trgm extension needs the CREATE privilege on the database,
+// unless it is installed already, and the index slows down inserts of
+// output rows. The index is built without locking the table against
+// writes.
+func CreateOutputSearchIndex(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return ErrSearchIndexUnsupported
+	}
+
+	err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error
+	if err != nil {
+		return err
+	}
+
+	// An interrupted concurrent build leaves an invalid index behind, which
+	// IF NOT EXISTS would keep.
+	err = dropInvalidIndex(db, outputSearchIndex)
+	if err != nil {
+		return err
+	}
+
+	return db.Exec(
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS " + outputSearchIndex +
+			" ON job_output_rows USING gin (text gin_trgm_ops)",
+	).Error
+}
+
+// DropOutputSearchIndex drops the trigram index of output rows. The pg_trgm
+// extension is kept.
+func DropOutputSearchIndex(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return ErrSearchIndexUnsupported
+	}
+
+	return db.Exec("DROP INDEX CONCURRENTLY IF EXISTS " + outputSearchIndex).Error
+}
+
+func dropInvalidIndex(db *gorm.DB, name string) error {
+	var invalid int64
+	err := db.Raw(
+		"SELECT COUNT(*) FROM pg_index JOIN pg_class ON pg_class.oid = pg_index.indexrelid "+
+			"WHERE pg_class.relname = ? AND NOT pg_index.indisvalid",
+		name,
+	).Scan(&invalid).Error
+	if err != nil || invalid == 0 {
+		return err
+	}
+
+	return db.Exec("DROP INDEX CONCURRENTLY IF EXISTS " + name).Error
+}
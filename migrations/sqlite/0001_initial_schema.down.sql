@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS job_output_rows;
+DROP TABLE IF EXISTS jobs;
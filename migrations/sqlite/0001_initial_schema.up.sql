@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS jobs
+(
+    id              TEXT PRIMARY KEY,
+    created_at      DATETIME,
+    updated_at      DATETIME,
+    deleted_at      DATETIME,
+    source_code_b64 TEXT,
+    exit_code       INTEGER,
+    status          TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_deleted_at ON jobs (deleted_at);
+
+CREATE TABLE IF NOT EXISTS job_output_rows
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    text       TEXT,
+    job_id     TEXT REFERENCES jobs (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_output_rows_deleted_at ON job_output_rows (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_job_output_rows_job_id ON job_output_rows (job_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_jobs_batch_id;
+
+ALTER TABLE jobs DROP COLUMN batch_id;
+
+DROP TABLE IF EXISTS job_batches;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS job_env_vars;
+DROP TABLE IF EXISTS job_arguments;
+DROP TABLE IF EXISTS job_files;
+
+ALTER TABLE jobs DROP COLUMN entry_point;
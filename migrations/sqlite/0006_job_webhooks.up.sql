@@ -0,0 +1,39 @@
+ALTER TABLE jobs ADD COLUMN callback_url TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at      DATETIME,
+    updated_at      DATETIME,
+    deleted_at      DATETIME,
+    job_id          TEXT    NOT NULL REFERENCES jobs (id),
+    url             TEXT    NOT NULL,
+    event           TEXT    NOT NULL,
+    payload         TEXT    NOT NULL,
+    status          TEXT    NOT NULL DEFAULT 'pending',
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME,
+    delivered_at    DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_deleted_at ON webhook_deliveries (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_job_id ON webhook_deliveries (job_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status_next_attempt_at ON webhook_deliveries (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts
+(
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at     DATETIME,
+    updated_at     DATETIME,
+    deleted_at     DATETIME,
+    delivery_id    INTEGER NOT NULL REFERENCES webhook_deliveries (id),
+    job_id         TEXT    NOT NULL REFERENCES jobs (id),
+    attempt_number INTEGER NOT NULL,
+    status_code    INTEGER,
+    error          TEXT    NOT NULL DEFAULT '',
+    duration_ms    INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_deleted_at ON webhook_attempts (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery_id ON webhook_attempts (delivery_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_job_id ON webhook_attempts (job_id);
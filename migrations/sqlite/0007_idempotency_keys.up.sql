@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at      DATETIME,
+    updated_at      DATETIME,
+    deleted_at      DATETIME,
+    idempotency_key TEXT     NOT NULL,
+    request_hash    TEXT     NOT NULL,
+    status_code     INTEGER  NOT NULL DEFAULT 0,
+    response        TEXT     NOT NULL DEFAULT '',
+    expires_at      DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_deleted_at ON idempotency_keys (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_idempotency_key ON idempotency_keys (idempotency_key);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
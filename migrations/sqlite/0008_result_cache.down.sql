@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS result_cache_entries;
+
+DROP INDEX IF EXISTS idx_jobs_cached_from_id;
+ALTER TABLE jobs DROP COLUMN cached_from_id;
+ALTER TABLE jobs DROP COLUMN cached;
+ALTER TABLE jobs DROP COLUMN cache_key;
@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
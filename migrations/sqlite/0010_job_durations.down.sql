@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_jobs_lang_version_finished_at;
+DROP INDEX IF EXISTS idx_jobs_status_lang_version;
+
+ALTER TABLE jobs DROP COLUMN duration_ms;
+ALTER TABLE jobs DROP COLUMN finished_at;
+ALTER TABLE jobs DROP COLUMN started_at;
+ALTER TABLE jobs DROP COLUMN lang_version;
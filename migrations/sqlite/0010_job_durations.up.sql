@@ -0,0 +1,7 @@
+ALTER TABLE jobs ADD COLUMN lang_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN started_at DATETIME;
+ALTER TABLE jobs ADD COLUMN finished_at DATETIME;
+ALTER TABLE jobs ADD COLUMN duration_ms INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status_lang_version ON jobs (status, lang_version);
+CREATE INDEX IF NOT EXISTS idx_jobs_lang_version_finished_at ON jobs (lang_version, finished_at);
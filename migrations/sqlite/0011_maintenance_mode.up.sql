@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS maintenance_modes
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME,
+    enabled    NUMERIC NOT NULL DEFAULT 0,
+    message    TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_maintenance_modes_deleted_at ON maintenance_modes (deleted_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_jobs_status_next_retry_at;
+
+ALTER TABLE jobs DROP COLUMN next_retry_at;
+ALTER TABLE jobs DROP COLUMN last_error;
+ALTER TABLE jobs DROP COLUMN attempts;
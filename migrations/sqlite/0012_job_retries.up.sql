@@ -0,0 +1,5 @@
+ALTER TABLE jobs ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN next_retry_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status_next_retry_at ON jobs (status, next_retry_at);
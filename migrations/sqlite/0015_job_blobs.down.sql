@@ -0,0 +1,2 @@
+ALTER TABLE jobs DROP COLUMN output_blob_key;
+ALTER TABLE jobs DROP COLUMN source_blob_key;
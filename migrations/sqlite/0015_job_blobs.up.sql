@@ -0,0 +1,2 @@
+ALTER TABLE jobs ADD COLUMN source_blob_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN output_blob_key TEXT NOT NULL DEFAULT '';
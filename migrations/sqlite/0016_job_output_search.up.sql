@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_job_output_rows_job_id_id ON job_output_rows (job_id, id);
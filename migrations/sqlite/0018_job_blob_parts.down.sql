@@ -0,0 +1,3 @@
+ALTER TABLE jobs DROP COLUMN output_part_lines;
+ALTER TABLE jobs DROP COLUMN output_lines;
+ALTER TABLE job_files DROP COLUMN blob_key;
@@ -8,21 +8,63 @@
 
 package rmq
 
+const (
+	// JobMessageV1 carries a single source file in SourceCodeB64.
+	JobMessageV1 = 1
+
+	// JobMessageV2 carries a gzipped tar archive of the project in
+	// ArchiveB64 with the entry point, program arguments and environment.
+	JobMessageV2 = 2
+)
+
+// JobMessage of the "test" kind asks the worker to run the program once for
+// every test case and report each run with a "test_result" message before
+// the final "exit" one. Priority is also set on the AMQP message.
 type JobMessage struct {
-	ID            string `json:"id"`
-	LangVersion   string `json:"lang_version"`
-	SourceCodeB64 string `json:"source_code_b64"`
+	Version       int               `json:"version"`
+	ID            string            `json:"id"`
+	Kind          string            `json:"kind"`
+	LangVersion   string            `json:"lang_version"`
+	Priority      uint8             `json:"priority,omitempty"`
+	SourceCodeB64 string            `json:"source_code_b64,omitempty"`
+	ArchiveB64    string            `json:"archive_b64,omitempty"`
+	EntryPoint    string            `json:"entry_point,omitempty"`
+	Args          []string          `json:"args,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	TestCases     []TestCaseMessage `json:"test_cases,omitempty"`
+
+	// Attempt numbers the publications of the job. Workers echo it in
+	// every result, so that results of an earlier run of a retried or
+	// requeued job are told apart and dropped.
+	Attempt int `json:"attempt"`
+}
+
+type TestCaseMessage struct {
+	Index    int    `json:"index"`
+	StdinB64 string `json:"stdin_b64"`
 }
 
 type jobResultType string
 
+// jobResultWorkerError reports a failure of the worker itself, e.g. of its
+// sandbox, rather than of the program; such jobs are retried.
 const (
-	jobResultLog  jobResultType = "log"
-	jobResultExit               = "exit"
+	jobResultLog         jobResultType = "log"
+	jobResultExit                      = "exit"
+	jobResultTestResult                = "test_result"
+	jobResultWorkerError               = "worker_error"
 )
 
 type JobResultMessage struct {
-	ID   string        `json:"id"`
-	Type jobResultType `json:"type"`
-	Data string        `json:"data"`
+	ID       string                 `json:"id"`
+	Type     jobResultType          `json:"type"`
+	Data     string                 `json:"data"`
+	TestCase *TestCaseResultMessage `json:"test_case,omitempty"`
+	Attempt  int                    `json:"attempt"`
+}
+
+type TestCaseResultMessage struct {
+	Index    int    `json:"index"`
+	Stdout   string `json:"stdout"`
+	ExitCode int    `json:"exit_code"`
 }
@@ -0,0 +1,359 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package rmq
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultResultConsumers     = 1
+	defaultResultBatchSize     = 1
+	defaultResultFlushInterval = 100 * time.Millisecond
+)
+
+// resultDelivery is a message with its parsed result.
+type resultDelivery struct {
+	amqp.Delivery
+	result *JobResultMessage
+}
+
+// outputBatch collects log lines of several messages, so that they are
+// inserted at once. Attempts are the attempts of the jobs the lines were
+// sent for.
+type outputBatch struct {
+	deliveries []amqp.Delivery
+	rows       []jobs.JobOutputRow
+	attempts   []int
+}
+
+func (b *outputBatch) add(d amqp.Delivery, result *JobResultMessage) {
+	b.deliveries = append(b.deliveries, d)
+	b.rows = append(b.rows, jobs.JobOutputRow{JobID: result.ID, Text: result.Data})
+	b.attempts = append(b.attempts, result.Attempt)
+}
+
+func (b *outputBatch) reset() {
+	b.deliveries = b.deliveries[:0]
+	b.rows = b.rows[:0]
+	b.attempts = b.attempts[:0]
+}
+
+// jobAttempt identifies a run of a job.
+type jobAttempt struct {
+	jobId   string
+	attempt int
+}
+
+func (mq *RabbitMQJobService) resultConsumers() int {
+	if mq.ResultConsumers <= 0 {
+		return defaultResultConsumers
+	}
+
+	return mq.ResultConsumers
+}
+
+func (mq *RabbitMQJobService) resultPrefetch() int {
+	if mq.ResultPrefetch <= 0 {
+		return mq.resultBatchSize() * mq.resultConsumers()
+	}
+
+	return mq.ResultPrefetch
+}
+
+func (mq *RabbitMQJobService) resultBatchSize() int {
+	if mq.ResultBatchSize <= 0 {
+		return defaultResultBatchSize
+	}
+
+	return mq.ResultBatchSize
+}
+
+func (mq *RabbitMQJobService) resultFlushInterval() time.Duration {
+	if mq.ResultFlushInterval <= 0 {
+		return defaultResultFlushInterval
+	}
+
+	return mq.ResultFlushInterval
+}
+
+// processMessagesAsync dispatches results to the result workers by the
+// hash of their job ID, so that the results of a job are processed in order
+// by one worker while other jobs are processed concurrently. A busy worker
+// blocks the dispatch, and as the prefetch count bounds unacknowledged
+// messages, the broker stops delivering until the database catches up.
+func (mq *RabbitMQJobService) processMessagesAsync(messages <-chan amqp.Delivery) {
+	defer mq.consumer.setConsuming(false)
+
+	acks := newAckTracker()
+	workers := make([]chan resultDelivery, mq.resultConsumers())
+	var wg sync.WaitGroup
+	for i := range workers {
+		workers[i] = make(chan resultDelivery, mq.resultBatchSize())
+		wg.Add(1)
+		go func(deliveries <-chan resultDelivery) {
+			defer wg.Done()
+			mq.processResults(deliveries, acks)
+		}(workers[i])
+	}
+
+	for d := range messages {
+		jobResult := &JobResultMessage{}
+		err := json.Unmarshal(d.Body, jobResult)
+		if err != nil {
+			mq.settle(acks, d, err)
+			continue
+		}
+
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(jobResult.ID))
+		workers[hash.Sum32()%uint32(len(workers))] <- resultDelivery{Delivery: d, result: jobResult}
+	}
+
+	for _, worker := range workers {
+		close(worker)
+	}
+
+	wg.Wait()
+}
+
+// processResults stores log lines in batches which are flushed when they
+// are full, when the flush interval has passed since their first line or
+// before any other result, so that the outputs of a job are stored before
+// it finishes. Other results are processed one by one.
+//
+// Lines which failed to be stored are requeued, and so are later results
+// of their jobs until the requeued messages come back, so that a job does
+// not finish without them and its lines stay in order.
+func (mq *RabbitMQJobService) processResults(deliveries <-chan resultDelivery, acks *ackTracker) {
+	batch := &outputBatch{}
+	stalled := map[string]bool{}
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+
+	flush := func() {
+		if len(batch.deliveries) > 0 {
+			timer.Stop()
+			for _, id := range mq.flushOutputs(batch, acks) {
+				stalled[id] = true
+			}
+		}
+	}
+
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				flush()
+				return
+			}
+
+			if d.result.Type != jobResultLog {
+				flush()
+			}
+
+			if stalled[d.result.ID] {
+				if !d.Redelivered {
+					mq.requeue(acks, d.Delivery)
+					continue
+				}
+
+				delete(stalled, d.result.ID)
+			}
+
+			if d.result.Type == jobResultLog {
+				if len(batch.deliveries) == 0 {
+					timer.Reset(mq.resultFlushInterval())
+				}
+
+				batch.add(d.Delivery, d.result)
+				if len(batch.deliveries) >= mq.resultBatchSize() {
+					flush()
+				}
+
+				continue
+			}
+
+			mq.settle(acks, d.Delivery, mq.processJobResult(d.result))
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// flushOutputs stores the lines of the batch and settles its messages. It
+// returns the IDs of jobs whose lines failed to be stored and were
+// requeued.
+func (mq *RabbitMQJobService) flushOutputs(batch *outputBatch, acks *ackTracker) []string {
+	defer batch.reset()
+
+	err := mq.storeOutputs(batch.rows, batch.attempts)
+	if err != nil {
+		logging.Errorf("Failed to store %d lines of outputs: %v", len(batch.rows), err)
+	}
+
+	var requeued []string
+	for i, d := range batch.deliveries {
+		mq.consumer.record(err)
+		if err != nil {
+			logOrNil(d.Nack(false, !d.Redelivered))
+			if !d.Redelivered {
+				requeued = append(requeued, batch.rows[i].JobID)
+			}
+		}
+	}
+
+	acks.settle(err == nil, batch.deliveries...)
+	return requeued
+}
+
+// storeOutputs moves jobs which have not started yet to running and inserts
+// the lines of started jobs. Lines of missing jobs, late lines of finished
+// or rejected jobs and lines of earlier attempts are dropped.
+func (mq *RabbitMQJobService) storeOutputs(rows []jobs.JobOutputRow, attempts []int) error {
+	var ids []string
+	seen := map[string]bool{}
+	sent := map[jobAttempt]bool{}
+	for i, row := range rows {
+		sent[jobAttempt{row.JobID, attempts[i]}] = true
+		if !seen[row.JobID] {
+			seen[row.JobID] = true
+			ids = append(ids, row.JobID)
+		}
+	}
+
+	found, err := mq.JobService.GetJobs(ids)
+	if err != nil {
+		return err
+	}
+
+	running := map[string]*jobs.Job{}
+	now := time.Now()
+	for i := range found {
+		job := &found[i]
+		if !sent[jobAttempt{job.ID, job.Attempts}] && !sent[jobAttempt{job.ID, 0}] {
+			// All lines are of earlier attempts, which must not start the
+			// job again.
+			continue
+		}
+
+		if job.Status != jobs.JobStatusRunning {
+			job.MarkStarted(now)
+			err = mq.JobService.TransitionJob(job, jobs.JobStatusRunning, "worker started", "started_at")
+			var transitionErr *jobs.TransitionError
+			if errors.As(err, &transitionErr) {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		running[job.ID] = job
+	}
+
+	stored := rows[:0:0]
+	for i, row := range rows {
+		if job, ok := running[row.JobID]; ok && isCurrentAttempt(attempts[i], job) {
+			stored = append(stored, row)
+		}
+	}
+
+	if dropped := len(rows) - len(stored); dropped > 0 {
+		logging.Debugf("dropping %d lines of outputs of missing or inactive jobs or earlier attempts", dropped)
+	}
+
+	if mq.OutputChunkLines > 0 {
+		return mq.JobService.AppendOutputChunks(stored, mq.OutputChunkLines)
+	}
+
+	return mq.JobService.AppendOutput(stored)
+}
+
+// settle acknowledges the processed message. A message which failed is
+// requeued once, so that it survives a transient failure, and dropped when
+// it fails again.
+func (mq *RabbitMQJobService) settle(acks *ackTracker, d amqp.Delivery, err error) {
+	mq.consumer.record(err)
+	if err != nil {
+		logging.Errorf("%v", err)
+		logOrNil(d.Nack(false, !d.Redelivered))
+	}
+
+	acks.settle(err == nil, d)
+}
+
+// requeue returns the message to the queue to be processed after the
+// messages of its job which were requeued before it.
+func (mq *RabbitMQJobService) requeue(acks *ackTracker, d amqp.Delivery) {
+	logOrNil(d.Nack(false, true))
+	acks.settle(false, d)
+}
+
+// ackTracker acknowledges messages settled by concurrent workers with a
+// single ack of the highest tag below which all messages are settled, since
+// a multiple ack of a worker would also acknowledge messages which other
+// workers have not processed yet. Rejected messages are settled too, but
+// they must not be acknowledged themselves.
+type ackTracker struct {
+	mu   sync.Mutex
+	next uint64
+
+	// settled maps tags of settled messages above next to whether they
+	// are to be acknowledged.
+	settled map[uint64]bool
+}
+
+func newAckTracker() *ackTracker {
+	// Delivery tags of a channel start from 1.
+	return &ackTracker{next: 1, settled: map[uint64]bool{}}
+}
+
+func (t *ackTracker) settle(ack bool, deliveries ...amqp.Delivery) {
+	if len(deliveries) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range deliveries {
+		t.settled[d.DeliveryTag] = ack
+	}
+
+	var last uint64
+	for {
+		ack, ok := t.settled[t.next]
+		if !ok {
+			break
+		}
+
+		if ack {
+			last = t.next
+		}
+
+		delete(t.settled, t.next)
+		t.next++
+	}
+
+	if last > 0 {
+		logOrNil(deliveries[0].Acknowledger.Ack(last, true))
+	}
+}
@@ -0,0 +1,351 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package rmq
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"borsch-playground-api/jobs"
+	"borsch-playground-api/migrations"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// stubAcknowledger records how messages were settled.
+type stubAcknowledger struct {
+	mu       sync.Mutex
+	acks     []uint64
+	nacks    []uint64
+	requeued []bool
+}
+
+func (a *stubAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !multiple {
+		return errors.New("single acks are not expected")
+	}
+
+	a.acks = append(a.acks, tag)
+	return nil
+}
+
+func (a *stubAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if multiple {
+		return errors.New("multiple nacks are not expected")
+	}
+
+	a.nacks = append(a.nacks, tag)
+	a.requeued = append(a.requeued, requeue)
+	return nil
+}
+
+func (a *stubAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// settleTags settles the messages of the tags with the tracker.
+func settleTags(acks *ackTracker, ack amqp.Acknowledger, settled bool, tags ...uint64) {
+	deliveries := make([]amqp.Delivery, len(tags))
+	for i, tag := range tags {
+		deliveries[i] = amqp.Delivery{Acknowledger: ack, DeliveryTag: tag}
+	}
+
+	acks.settle(settled, deliveries...)
+}
+
+func TestAckTrackerOutOfOrderSettles(t *testing.T) {
+	ack := &stubAcknowledger{}
+	acks := newAckTracker()
+	settleTags(acks, ack, true, 3)
+	settleTags(acks, ack, true, 2)
+	if len(ack.acks) != 0 {
+		t.Fatalf("acked %v before the first message is settled", ack.acks)
+	}
+
+	settleTags(acks, ack, true, 1)
+	settleTags(acks, ack, true, 5, 6)
+	settleTags(acks, ack, true, 4)
+	if fmt.Sprint(ack.acks) != "[3 6]" {
+		t.Errorf("acked up to %v, want 3 and then 6", ack.acks)
+	}
+}
+
+func TestAckTrackerInterleavedNacks(t *testing.T) {
+	ack := &stubAcknowledger{}
+	acks := newAckTracker()
+
+	// Nacked messages are settled by their nack, so that an ack of a later
+	// tag goes past them, but they are never acked themselves.
+	settleTags(acks, ack, false, 1)
+	settleTags(acks, ack, true, 2)
+	settleTags(acks, ack, false, 3, 4)
+	if fmt.Sprint(ack.acks) != "[2]" {
+		t.Fatalf("acked up to %v, want 2", ack.acks)
+	}
+
+	settleTags(acks, ack, true, 6)
+	settleTags(acks, ack, false, 5)
+	settleTags(acks, ack, true, 7)
+	settleTags(acks, ack, false, 8)
+	if fmt.Sprint(ack.acks) != "[2 6 7]" {
+		t.Errorf("acked up to %v, want 2, 6 and 7", ack.acks)
+	}
+
+	if acks.next != 9 || len(acks.settled) != 0 {
+		t.Errorf("tracker waits for tag %d with %d settled messages", acks.next, len(acks.settled))
+	}
+}
+
+func TestAckTrackerFinalMultipleAck(t *testing.T) {
+	const messages = 1000
+	const workers = 8
+	ack := &stubAcknowledger{}
+	acks := newAckTracker()
+
+	tags := make(chan uint64, messages)
+	for _, i := range rand.Perm(messages) {
+		tags <- uint64(i + 1)
+	}
+
+	close(tags)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tag := range tags {
+				settleTags(acks, ack, tag%10 != 0, tag)
+			}
+		}()
+	}
+
+	wg.Wait()
+	for i := 1; i < len(ack.acks); i++ {
+		if ack.acks[i] <= ack.acks[i-1] {
+			t.Fatalf("acks %v are not increasing", ack.acks)
+		}
+	}
+
+	// The last message is nacked, so the final ack is of the one before it.
+	if len(ack.acks) == 0 || ack.acks[len(ack.acks)-1] != messages-1 {
+		t.Errorf("last ack is not of tag %d: %v", messages-1, ack.acks)
+	}
+
+	if acks.next != messages+1 || len(acks.settled) != 0 {
+		t.Errorf("tracker waits for tag %d with %d settled messages", acks.next, len(acks.settled))
+	}
+}
+
+func newTestJobService(tb testing.TB) jobs.JobService {
+	db, err := gorm.Open(
+		sqlite.Open(filepath.Join(tb.TempDir(), "test.sqlite")),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)},
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	err = migrations.Migrate(db)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return jobs.NewJobServiceImpl(db)
+}
+
+func createJob(tb testing.TB, js jobs.JobService, id string, status jobs.JobStatus, attempts int) {
+	job := &jobs.Job{Kind: jobs.JobKindRun, LangVersion: "0.1.0", Status: status, Attempts: attempts}
+	job.ID = id
+	err := js.CreateJob(job)
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func createQueuedJob(tb testing.TB, js jobs.JobService, id string) {
+	createJob(tb, js, id, jobs.JobStatusQueued, 1)
+}
+
+func newResultDelivery(ack amqp.Acknowledger, tag uint64, redelivered bool, result *JobResultMessage) resultDelivery {
+	return resultDelivery{
+		Delivery: amqp.Delivery{Acknowledger: ack, DeliveryTag: tag, Redelivered: redelivered},
+		result:   result,
+	}
+}
+
+// failingOutputService fails to store outputs the given number of times.
+type failingOutputService struct {
+	jobs.JobService
+
+	failures int
+}
+
+func (s *failingOutputService) AppendOutput(rows []jobs.JobOutputRow) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("database is unavailable")
+	}
+
+	return s.JobService.AppendOutput(rows)
+}
+
+func TestFailedFlushRequeuesLaterResultsOfJob(t *testing.T) {
+	js := newTestJobService(t)
+	createQueuedJob(t, js, "job")
+	createQueuedJob(t, js, "other")
+
+	ack := &stubAcknowledger{}
+	line := &JobResultMessage{ID: "job", Type: jobResultLog, Data: "line", Attempt: 1}
+	exit := &JobResultMessage{ID: "job", Type: jobResultExit, Data: "0", Attempt: 1}
+	otherExit := &JobResultMessage{ID: "other", Type: jobResultExit, Data: "1", Attempt: 1}
+	deliveries := make(chan resultDelivery, 5)
+	deliveries <- newResultDelivery(ack, 1, false, line)
+	deliveries <- newResultDelivery(ack, 2, false, exit)
+	deliveries <- newResultDelivery(ack, 3, false, otherExit)
+
+	// The requeued messages come back after the flush failed.
+	deliveries <- newResultDelivery(ack, 4, true, line)
+	deliveries <- newResultDelivery(ack, 5, true, exit)
+	close(deliveries)
+
+	mq := &RabbitMQJobService{
+		JobService:      &failingOutputService{JobService: js, failures: 1},
+		ResultBatchSize: 10,
+	}
+	mq.processResults(deliveries, newAckTracker())
+
+	if fmt.Sprint(ack.nacks, ack.requeued) != "[1 2] [true true]" {
+		t.Errorf("nacked %v with requeue %v, want the line and the exit requeued", ack.nacks, ack.requeued)
+	}
+
+	if fmt.Sprint(ack.acks) != "[3 4 5]" {
+		t.Errorf("acked up to %v", ack.acks)
+	}
+
+	job, err := js.GetJob("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs, err := js.GetJobOutputs("job", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Status != jobs.JobStatusFinished || len(outputs) != 1 {
+		t.Errorf("job is %s with %d lines, want it finished with its line", job.Status, len(outputs))
+	}
+}
+
+func TestResultsOfCurrentAttemptAreStored(t *testing.T) {
+	js := newTestJobService(t)
+
+	// A fast worker reports before the job is marked as queued, a worker
+	// which predates attempts sends none, and a stale worker reports a run
+	// of a job which has been requeued since.
+	createJob(t, js, "fast", jobs.JobStatusAccepted, 1)
+	createQueuedJob(t, js, "legacy")
+	createJob(t, js, "stale", jobs.JobStatusQueued, 2)
+
+	ack := &stubAcknowledger{}
+	deliveries := make(chan resultDelivery, 6)
+	tag := uint64(0)
+	for id, attempt := range map[string]int{"fast": 1, "legacy": 0, "stale": 1} {
+		for _, result := range []*JobResultMessage{
+			{ID: id, Type: jobResultLog, Data: "line", Attempt: attempt},
+			{ID: id, Type: jobResultExit, Data: "0", Attempt: attempt},
+		} {
+			tag++
+			deliveries <- newResultDelivery(ack, tag, false, result)
+		}
+	}
+
+	close(deliveries)
+	mq := &RabbitMQJobService{JobService: js, ResultBatchSize: 10}
+	mq.processResults(deliveries, newAckTracker())
+
+	for id, want := range map[string]jobs.JobStatus{
+		"fast":   jobs.JobStatusFinished,
+		"legacy": jobs.JobStatusFinished,
+		"stale":  jobs.JobStatusQueued,
+	} {
+		job, err := js.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outputs, err := js.GetJobOutputs(id, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job.Status != want || (len(outputs) == 1) != (want == jobs.JobStatusFinished) {
+			t.Errorf("job %s is %s with %d lines, want it %s", id, job.Status, len(outputs), want)
+		}
+	}
+
+	if len(ack.nacks) != 0 || len(ack.acks) == 0 || ack.acks[len(ack.acks)-1] != 6 {
+		t.Errorf("acked %v and nacked %v, want all acked", ack.acks, ack.nacks)
+	}
+}
+
+// BenchmarkProcessResults100kLines stores the output of a program printing
+// 100k lines and its exit.
+func BenchmarkProcessResults100kLines(b *testing.B) {
+	const lines = 100000
+	for _, batchSize := range []int{200, 1000} {
+		b.Run(
+			"batch="+strconv.Itoa(batchSize), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					js := newTestJobService(b)
+					createQueuedJob(b, js, "job")
+
+					ack := &stubAcknowledger{}
+					deliveries := make(chan resultDelivery, lines+1)
+					for tag := uint64(1); tag <= lines; tag++ {
+						deliveries <- newResultDelivery(
+							ack,
+							tag,
+							false,
+							&JobResultMessage{ID: "job", Type: jobResultLog, Data: "line " + strconv.Itoa(int(tag)), Attempt: 1},
+						)
+					}
+
+					exit := &JobResultMessage{ID: "job", Type: jobResultExit, Data: "0", Attempt: 1}
+					deliveries <- newResultDelivery(ack, lines+1, false, exit)
+					close(deliveries)
+
+					mq := &RabbitMQJobService{JobService: js, ResultBatchSize: batchSize}
+					b.StartTimer()
+					mq.processResults(deliveries, newAckTracker())
+					b.StopTimer()
+
+					outputs, err := js.GetJobOutputs("job", lines-1, 10)
+					if err != nil || len(outputs) != 1 || len(ack.nacks) != 0 {
+						b.Fatalf("output is not complete: %v", err)
+					}
+				}
+			},
+		)
+	}
+}
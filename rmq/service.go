@@ -13,35 +13,71 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
 	"strconv"
 	"time"
 
 	"borsch-playground-api/jobs"
+	"borsch-playground-api/logging"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
 )
 
 type AMQPJobService interface {
 	ConsumeJobResults() error
 	PublishJob(job *JobMessage) error
+	PublishJobs(jobs []*JobMessage) []error
+	GetQueueStats() ([]QueueStats, error)
+	GetConsumerStatus() ConsumerStatus
 }
 
-const (
-	EnvRabbitMQServer      = "RABBITMQ_SERVER"
-	EnvRabbitMQJobQueue    = "RABBITMQ_JOB_QUEUE"
-	EnvRabbitMQResultQueue = "RABBITMQ_RESULT_QUEUE"
-)
+// JobQueue receives jobs of the given language versions from the job
+// exchange.
+type JobQueue struct {
+	Name     string
+	Versions []string
+}
 
+// RabbitMQJobService publishes jobs to JobQueue or, if JobExchange is set,
+// to the direct exchange which routes them by language version to one of
+// JobQueues. Job queues are priority queues when MaxPriority is positive.
 type RabbitMQJobService struct {
-	Server     string
-	JobService jobs.JobService
+	Server      string
+	JobQueue    string
+	ResultQueue string
+	JobExchange string
+	JobQueues   []JobQueue
+	MaxPriority uint8
+	JobService  jobs.JobService
+
+	// ResultCache returns the current policy of the result cache, nil
+	// disables caching.
+	ResultCache func() jobs.ResultCachePolicy
+
+	// Retry returns the current retry policy of jobs failed by a worker
+	// error, nil disables retries.
+	Retry func() jobs.RetryPolicy
+
+	// ResultConsumers is the number of workers processing results
+	// concurrently, each inserting up to ResultBatchSize log lines at once.
+	// ResultFlushInterval bounds how long a line waits for its batch.
+	// ResultPrefetch bounds unacknowledged results, by default it is
+	// enough to fill a batch of every worker.
+	ResultConsumers     int
+	ResultPrefetch      int
+	ResultBatchSize     int
+	ResultFlushInterval time.Duration
+
+	// OutputChunkLines stores outputs as chunks of up to this number of
+	// lines instead of a row per line if positive.
+	OutputChunkLines int
 
 	connection       *amqp.Connection
 	jobChannel       *amqp.Channel
 	jobResultChannel *amqp.Channel
 	jobQueue         amqp.Queue
 	jobResultQueue   amqp.Queue
+	boundVersions    map[string]bool
+	consumer         consumerState
 }
 
 func (mq *RabbitMQJobService) Setup() error {
@@ -51,16 +87,61 @@ func (mq *RabbitMQJobService) Setup() error {
 	}
 
 	mq.connection = connection
-	mq.jobChannel, mq.jobQueue, err = createQueue(connection, os.Getenv(EnvRabbitMQJobQueue))
+	mq.jobChannel, err = openChannel(connection, 1)
 	if err != nil {
 		return err
 	}
 
-	mq.jobResultChannel, mq.jobResultQueue, err = createQueue(connection, os.Getenv(EnvRabbitMQResultQueue))
+	err = mq.declareJobQueues()
 	if err != nil {
 		return err
 	}
 
+	mq.jobResultChannel, err = openChannel(connection, mq.resultPrefetch())
+	if err != nil {
+		return err
+	}
+
+	mq.jobResultQueue, err = declareQueue(mq.jobResultChannel, mq.ResultQueue, nil)
+	return err
+}
+
+// declareJobQueues declares the job queue or the job exchange with its
+// queues and bindings.
+func (mq *RabbitMQJobService) declareJobQueues() error {
+	args := amqp.Table{}
+	if mq.MaxPriority > 0 {
+		args["x-max-priority"] = int32(mq.MaxPriority)
+	}
+
+	var err error
+	if mq.JobExchange == "" {
+		mq.jobQueue, err = declareQueue(mq.jobChannel, mq.JobQueue, args)
+		return err
+	}
+
+	err = mq.jobChannel.ExchangeDeclare(mq.JobExchange, amqp.ExchangeDirect, true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare an exchange: %v", err)
+	}
+
+	mq.boundVersions = map[string]bool{}
+	for _, queue := range mq.JobQueues {
+		_, err = declareQueue(mq.jobChannel, queue.Name, args)
+		if err != nil {
+			return err
+		}
+
+		for _, version := range queue.Versions {
+			err = mq.jobChannel.QueueBind(queue.Name, version, mq.JobExchange, false, nil)
+			if err != nil {
+				return fmt.Errorf("failed to bind queue %s: %v", queue.Name, err)
+			}
+
+			mq.boundVersions[version] = true
+		}
+	}
+
 	return nil
 }
 
@@ -76,6 +157,7 @@ func (mq *RabbitMQJobService) ConsumeJobResults() error {
 		return fmt.Errorf("failed to register a consumer: %v", err)
 	}
 
+	mq.consumer.setConsuming(true)
 	go mq.processMessagesAsync(messages)
 	return nil
 }
@@ -84,6 +166,35 @@ func (mq *RabbitMQJobService) PublishJob(job *JobMessage) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	return mq.publishJob(ctx, job)
+}
+
+// PublishJobs publishes all jobs within a single timeout and returns an
+// error for each of them, nil if the job was published.
+func (mq *RabbitMQJobService) PublishJobs(jobs []*JobMessage) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second+time.Duration(len(jobs))*10*time.Millisecond)
+	defer cancel()
+
+	errs := make([]error, len(jobs))
+	for i, job := range jobs {
+		errs[i] = mq.publishJob(ctx, job)
+	}
+
+	return errs
+}
+
+func (mq *RabbitMQJobService) publishJob(ctx context.Context, job *JobMessage) error {
+	exchange, key := "", mq.jobQueue.Name
+	if mq.JobExchange != "" {
+		// Bindings are declared on start only, so versions added by a
+		// settings reload are not routed until restart.
+		if !mq.boundVersions[job.LangVersion] {
+			return fmt.Errorf("no job queue is bound to version %s", job.LangVersion)
+		}
+
+		exchange, key = mq.JobExchange, job.LangVersion
+	}
+
 	body, err := json.Marshal(job)
 	if err != nil {
 		return err
@@ -91,13 +202,14 @@ func (mq *RabbitMQJobService) PublishJob(job *JobMessage) error {
 
 	err = mq.jobChannel.PublishWithContext(
 		ctx,
-		"",
-		mq.jobQueue.Name,
+		exchange,
+		key,
 		false,
 		false,
 		amqp.Publishing{
 			DeliveryMode: amqp.Persistent,
 			ContentType:  "text/plain",
+			Priority:     job.Priority,
 			Body:         body,
 		},
 	)
@@ -108,73 +220,203 @@ func (mq *RabbitMQJobService) PublishJob(job *JobMessage) error {
 	return nil
 }
 
-func (mq *RabbitMQJobService) processJobResult(data []byte) error {
-	jobResult := JobResultMessage{}
-	err := json.Unmarshal(data, &jobResult)
+// processJobResult processes a result other than a log line, which are
+// stored in batches by processMessagesAsync.
+func (mq *RabbitMQJobService) processJobResult(jobResult *JobResultMessage) error {
+	job, err := mq.JobService.GetJob(jobResult.ID)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The job was deleted, its results are of no use anymore.
+			logging.Debugf("dropping result of missing job %s", jobResult.ID)
+			return nil
+		}
+
 		return err
 	}
 
-	job, err := mq.JobService.GetJob(jobResult.ID)
-	if err != nil {
-		return err
+	if !isCurrentAttempt(jobResult.Attempt, job) {
+		logging.Debugf(
+			"dropping result of attempt %d of job %s, which is at attempt %d",
+			jobResult.Attempt,
+			job.ID,
+			job.Attempts,
+		)
+		return nil
 	}
 
+	job.MarkStarted(time.Now())
 	switch jobResult.Type {
-	case jobResultLog:
-		job.Outputs = append(job.Outputs, jobs.JobOutputRow{Text: jobResult.Data})
-		job.Status = jobs.JobStatusRunning
 	case jobResultExit:
 		job.ExitCode = new(int)
 		*job.ExitCode, err = strconv.Atoi(jobResult.Data)
-		job.Status = jobs.JobStatusFinished
+		if err != nil {
+			logging.Errorf("Invalid exit code of job %s: %v", job.ID, err)
+		}
+
+		job.MarkFinished(time.Now())
+		err = mq.JobService.TransitionJob(
+			job,
+			jobs.JobStatusFinished,
+			fmt.Sprintf("exited with code %d", *job.ExitCode),
+			"exit_code",
+			"started_at",
+			"finished_at",
+			"duration_ms",
+		)
+	case jobResultTestResult:
+		if jobResult.TestCase == nil {
+			return errors.New("test case result is not provided")
+		}
+
+		err = mq.JobService.TransitionJob(job, jobs.JobStatusRunning, "worker started", "started_at")
+		if err != nil {
+			break
+		}
+
+		testCase, err := mq.JobService.GetTestCase(job.ID, jobResult.TestCase.Index)
+		if err != nil {
+			return fmt.Errorf("test case %d of job %s: %v", jobResult.TestCase.Index, job.ID, err)
+		}
+
+		testCase.Evaluate(jobResult.TestCase.Stdout, jobResult.TestCase.ExitCode)
+		return mq.JobService.UpdateTestCase(testCase)
+	case jobResultWorkerError:
+		job.ScheduleRetry(fmt.Errorf("worker error: %s", jobResult.Data), mq.retryPolicy(), time.Now())
+		logging.Warningf("Worker failed to run job %s: %s", job.ID, jobResult.Data)
+		err = mq.JobService.TransitionJob(
+			job, jobs.JobStatusRejected, job.LastError, "started_at", "last_error", "next_retry_at",
+		)
 	default:
 		return fmt.Errorf("invalid type of job result: %s", jobResult.Type)
 	}
 
-	return mq.JobService.UpdateJob(job)
+	var transitionErr *jobs.TransitionError
+	if errors.As(err, &transitionErr) {
+		// The run has ended otherwise, e.g. the job was finished by an
+		// operator, so its result is dropped.
+		return nil
+	}
+
+	if err != nil || job.Status != jobs.JobStatusFinished {
+		return err
+	}
+
+	mq.compactOutput(job)
+	mq.cacheResult(job)
+	mq.archiveOutput(job)
+	if job.CallbackUrl == "" {
+		return nil
+	}
+
+	return mq.enqueueWebhook(job)
 }
 
-func (mq *RabbitMQJobService) processMessagesAsync(messages <-chan amqp.Delivery) {
-	for d := range messages {
-		err := mq.processJobResult(d.Body)
-		if err != nil {
-			log.Printf(err.Error())
-			continue
-		}
+func (mq *RabbitMQJobService) retryPolicy() jobs.RetryPolicy {
+	if mq.Retry == nil {
+		return jobs.RetryPolicy{}
+	}
+
+	return mq.Retry()
+}
+
+// compactOutput merges the chunks of the finished job's output which were
+// stored as the lines arrived. Failures are only logged, since the output
+// stays readable.
+func (mq *RabbitMQJobService) compactOutput(job *jobs.Job) {
+	if mq.OutputChunkLines <= 0 {
+		return
+	}
+
+	err := mq.JobService.CompactOutput(job.ID, mq.OutputChunkLines)
+	if err != nil {
+		logging.Errorf("Failed to compact output of job %s: %v", job.ID, err)
+	}
+}
+
+// archiveOutput moves the finished job's output into the blob store after
+// it was cached, since the size of cached outputs is taken from the
+// database. Failures are only logged, the output stays in the database.
+func (mq *RabbitMQJobService) archiveOutput(job *jobs.Job) {
+	err := mq.JobService.ArchiveOutput(job.ID)
+	if err != nil {
+		logging.Errorf("Failed to archive output of job %s: %v", job.ID, err)
+	}
+}
+
+// cacheResult stores the result of the finished job for reuse. Failures
+// are only logged, since the cache is an optimization.
+func (mq *RabbitMQJobService) cacheResult(job *jobs.Job) {
+	if job.CacheKey == "" || mq.ResultCache == nil {
+		return
+	}
+
+	policy := mq.ResultCache()
+	if policy.TTL <= 0 {
+		return
+	}
 
-		err = d.Ack(false)
+	err := mq.JobService.CacheResult(job, policy)
+	if err != nil {
+		logging.Errorf("Failed to cache result of job %s: %v", job.ID, err)
+	}
+}
+
+// enqueueWebhook schedules the delivery of the finished job's summary to
+// its callback URL.
+func (mq *RabbitMQJobService) enqueueWebhook(job *jobs.Job) error {
+	if job.Kind == jobs.JobKindTest {
+		testCases, err := mq.JobService.GetTestCases(job.ID)
 		if err != nil {
-			log.Printf(err.Error())
+			return err
 		}
+
+		job.TestSummary = jobs.Summarize(testCases)
 	}
-}
 
-func createQueue(connection *amqp.Connection, name string) (*amqp.Channel, amqp.Queue, error) {
-	if name == "" {
-		return nil, amqp.Queue{}, errors.New("RabbitMQ queue is not set")
+	delivery, err := jobs.NewWebhookDelivery(job)
+	if err != nil {
+		return err
 	}
 
+	return mq.JobService.CreateWebhookDelivery(delivery)
+}
+
+func openChannel(connection *amqp.Connection, prefetch int) (*amqp.Channel, error) {
 	channel, err := connection.Channel()
 	if err != nil {
-		return nil, amqp.Queue{}, fmt.Errorf("failed to open a channel: %v", err)
+		return nil, fmt.Errorf("failed to open a channel: %v", err)
 	}
 
-	queue, err := channel.QueueDeclare(name, true, false, false, false, nil)
+	err = channel.Qos(prefetch, 0, false)
 	if err != nil {
-		return nil, amqp.Queue{}, fmt.Errorf("failed to declare a queue: %v", err)
+		return nil, fmt.Errorf("failed to set QoS: %v", err)
+	}
+
+	return channel, nil
+}
+
+func declareQueue(channel *amqp.Channel, name string, args amqp.Table) (amqp.Queue, error) {
+	if name == "" {
+		return amqp.Queue{}, errors.New("RabbitMQ queue is not set")
 	}
 
-	err = channel.Qos(1, 0, false)
+	queue, err := channel.QueueDeclare(name, true, false, false, false, args)
 	if err != nil {
-		return nil, amqp.Queue{}, fmt.Errorf("failed to set QoS: %v", err)
+		return amqp.Queue{}, fmt.Errorf("failed to declare queue %s: %v", name, err)
 	}
 
-	return channel, queue, nil
+	return queue, nil
 }
 
 func logOrNil(err error) {
 	if err != nil {
-		log.Println(err)
+		logging.Errorf("%v", err)
 	}
 }
+
+// isCurrentAttempt reports whether a result of the attempt belongs to the
+// current attempt of the job. Workers which predate attempts send none, and
+// their results are taken as of the current attempt.
+func isCurrentAttempt(attempt int, job *jobs.Job) bool {
+	return attempt == 0 || attempt == job.Attempts
+}
@@ -0,0 +1,106 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package rmq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// QueueStats describes a job queue. Messages counts jobs waiting for a
+// worker, jobs being run are not included.
+type QueueStats struct {
+	Name      string
+	Messages  int
+	Consumers int
+}
+
+// ConsumerStatus describes the consumer of job results of this instance.
+type ConsumerStatus struct {
+	Queue         string     `json:"queue"`
+	Connected     bool       `json:"connected"`
+	Consuming     bool       `json:"consuming"`
+	Processed     int64      `json:"processed"`
+	Failed        int64      `json:"failed"`
+	LastMessageAt *time.Time `json:"last_message_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+}
+
+type consumerState struct {
+	mu     sync.Mutex
+	status ConsumerStatus
+}
+
+func (s *consumerState) setConsuming(consuming bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Consuming = consuming
+}
+
+func (s *consumerState) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.status.LastMessageAt = &now
+	if err == nil {
+		s.status.Processed++
+		return
+	}
+
+	s.status.Failed++
+	s.status.LastError = err.Error()
+	s.status.LastErrorAt = &now
+}
+
+// GetConsumerStatus returns the state of the result consumer and counters
+// of messages processed since start.
+func (mq *RabbitMQJobService) GetConsumerStatus() ConsumerStatus {
+	mq.consumer.mu.Lock()
+	status := mq.consumer.status
+	mq.consumer.mu.Unlock()
+
+	status.Queue = mq.ResultQueue
+	status.Connected = mq.connection != nil && !mq.connection.IsClosed()
+	return status
+}
+
+// GetQueueStats inspects the job queues with a passive declaration.
+func (mq *RabbitMQJobService) GetQueueStats() ([]QueueStats, error) {
+	names := []string{mq.JobQueue}
+	if mq.JobExchange != "" {
+		names = names[:0]
+		for _, queue := range mq.JobQueues {
+			names = append(names, queue.Name)
+		}
+	}
+
+	// A failed passive declaration closes the channel, so a separate one
+	// is used.
+	channel, err := mq.connection.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a channel: %v", err)
+	}
+
+	defer channel.Close()
+	stats := make([]QueueStats, len(names))
+	for i, name := range names {
+		queue, err := channel.QueueDeclarePassive(name, true, false, false, false, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect queue %s: %v", name, err)
+		}
+
+		stats[i] = QueueStats{Name: queue.Name, Messages: queue.Messages, Consumers: queue.Consumers}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,28 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+// minAdminTokenLength keeps the admin token from being guessed.
+const minAdminTokenLength = 16
+
+// Admin configures the operator API, which is disabled while Token is
+// empty. Requests are authenticated with "Authorization: Bearer <token>".
+type Admin struct {
+	Token string `json:"token" secret:"true"`
+}
+
+func (a *Admin) Enabled() bool {
+	return a != nil && a.Token != ""
+}
+
+func (a *Admin) validate(errs *ValidationErrors, field string) {
+	if a.Token != "" && len(a.Token) < minAdminTokenLength {
+		errs.add(field+".token", "must be at least %d characters long", minAdminTokenLength)
+	}
+}
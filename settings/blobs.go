@@ -0,0 +1,101 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+import "borsch-playground-api/blobs"
+
+const (
+	BlobBackendFilesystem = "filesystem"
+	BlobBackendS3         = "s3"
+)
+
+// Blobs moves large data out of the database into a filesystem directory
+// or an S3-compatible bucket. Projects whose source and files are longer
+// than SourceThresholdBytes together are stored there, zero keeps all
+// projects in the database; with ArchiveOutputs, outputs of finished jobs
+// are moved there as well.
+type Blobs struct {
+	Backend              string `json:"backend"`
+	Dir                  string `json:"dir"`
+	S3                   *S3    `json:"s3"`
+	SourceThresholdBytes int    `json:"source_threshold_bytes"`
+	ArchiveOutputs       bool   `json:"archive_outputs"`
+}
+
+type S3 struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key" secret:"true"`
+}
+
+func (b *Blobs) Enabled() bool {
+	return b != nil && b.Backend != ""
+}
+
+// Build returns the configured store, nil if blobs are disabled.
+func (b *Blobs) Build() (blobs.BlobStore, error) {
+	switch {
+	case !b.Enabled():
+		return nil, nil
+	case b.Backend == BlobBackendS3:
+		return blobs.NewS3Store(b.S3.Endpoint, b.S3.Region, b.S3.Bucket, b.S3.AccessKeyID, b.S3.SecretAccessKey)
+	default:
+		return blobs.NewFileStore(b.Dir)
+	}
+}
+
+func (b *Blobs) validate(errs *ValidationErrors, field string) {
+	switch b.Backend {
+	case "":
+		return
+	case BlobBackendFilesystem:
+		if b.Dir == "" {
+			errs.add(field+".dir", "directory is required for the filesystem backend")
+		}
+	case BlobBackendS3:
+		if b.S3 == nil {
+			errs.add(field+".s3", "S3 is required for the s3 backend")
+		} else {
+			b.S3.validate(errs, field+".s3")
+		}
+	default:
+		errs.add(
+			field+".backend",
+			"invalid backend, available values are '%s', '%s'",
+			BlobBackendFilesystem,
+			BlobBackendS3,
+		)
+	}
+
+	if b.SourceThresholdBytes < 0 {
+		errs.add(field+".source_threshold_bytes", "must not be negative")
+	}
+}
+
+func (s *S3) validate(errs *ValidationErrors, field string) {
+	if s.Endpoint == "" {
+		errs.add(field+".endpoint", "endpoint is required")
+	} else {
+		validateUrl(errs, field+".endpoint", s.Endpoint, "http", "https")
+	}
+
+	if s.Region == "" {
+		errs.add(field+".region", "region is required")
+	}
+
+	if s.Bucket == "" {
+		errs.add(field+".bucket", "bucket is required")
+	}
+
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		errs.add(field, "access_key_id and secret_access_key are required")
+	}
+}
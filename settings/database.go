@@ -11,18 +11,43 @@ package settings
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// PostgreSQL describes the connection either by DSN, which accepts both
+// the keyword/value and the URL formats, or by separate fields. Read
+// replicas are DSNs as well; only queries of sessions from UseReplicas are
+// routed to them.
 type PostgreSQL struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	DbName   string `json:"db_name"`
+	DSN          string   `json:"dsn" secret:"true"`
+	Host         string   `json:"host"`
+	Port         int      `json:"port"`
+	User         string   `json:"user"`
+	Password     string   `json:"password" secret:"true"`
+	DbName       string   `json:"db_name"`
+	ReadReplicas []string `json:"read_replicas" secret:"true"`
+
+	SSLMode     string `json:"ssl_mode"`
+	SSLRootCert string `json:"ssl_root_cert"`
+	SSLCert     string `json:"ssl_cert"`
+	SSLKey      string `json:"ssl_key"`
+
+	ConnectTimeoutSec  int `json:"connect_timeout_sec"`
+	StatementTimeoutMs int `json:"statement_timeout_ms"`
+
+	MaxOpenConns       int `json:"max_open_conns"`
+	MaxIdleConns       int `json:"max_idle_conns"`
+	ConnMaxLifetimeSec int `json:"conn_max_lifetime_sec"`
+	ConnMaxIdleTimeSec int `json:"conn_max_idle_time_sec"`
 }
 
 type Database struct {
@@ -30,6 +55,56 @@ type Database struct {
 	PostgreSQL *PostgreSQL `json:"postgresql"`
 }
 
+func (d *Database) validate(errs *ValidationErrors, field string) {
+	if d.SQLite3 != "" && d.PostgreSQL != nil {
+		errs.add(field, "exactly one of 'sqlite3' and 'postgresql' must be set, got both")
+	} else if d.SQLite3 == "" && d.PostgreSQL == nil {
+		errs.add(field, "exactly one of 'sqlite3' and 'postgresql' must be set, got none")
+	}
+
+	if d.PostgreSQL != nil {
+		d.PostgreSQL.validate(errs, field+".postgresql")
+	}
+}
+
+func (p *PostgreSQL) validate(errs *ValidationErrors, field string) {
+	if p.Port < 0 || p.Port > 65535 {
+		errs.add(field+".port", "must be between 1 and 65535")
+	}
+
+	if p.DSN == "" && p.DbName == "" {
+		errs.add(field+".db_name", "database name is required")
+	}
+
+	if p.SSLMode != "" && !stringArrayContains(sslModes, p.SSLMode) {
+		errs.add(field+".ssl_mode", "invalid SSL mode, available values are '%s'", strings.Join(sslModes, "', '"))
+	}
+
+	if (p.SSLCert == "") != (p.SSLKey == "") {
+		errs.add(field+".ssl_key", "client certificate and key must be set together")
+	}
+
+	for i, replica := range p.ReadReplicas {
+		if strings.TrimSpace(replica) == "" {
+			errs.add(fmt.Sprintf("%s.read_replicas[%d]", field, i), "DSN is empty")
+		}
+	}
+
+	nonNegative := map[string]int{
+		"connect_timeout_sec":    p.ConnectTimeoutSec,
+		"statement_timeout_ms":   p.StatementTimeoutMs,
+		"max_open_conns":         p.MaxOpenConns,
+		"max_idle_conns":         p.MaxIdleConns,
+		"conn_max_lifetime_sec":  p.ConnMaxLifetimeSec,
+		"conn_max_idle_time_sec": p.ConnMaxIdleTimeSec,
+	}
+	for name, value := range nonNegative {
+		if value < 0 {
+			errs.add(field+"."+name, "must not be negative")
+		}
+	}
+}
+
 func (d *Database) Build() (*gorm.DB, error) {
 	var dialector gorm.Dialector
 	if d.SQLite3 != "" {
@@ -44,10 +119,32 @@ func (d *Database) Build() (*gorm.DB, error) {
 
 	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
-		return nil, errors.New("failed to connect database")
+		return nil, fmt.Errorf("failed to connect database: %v", err)
+	}
+
+	if d.PostgreSQL != nil {
+		err = d.PostgreSQL.configure(db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure database: %v", err)
+		}
 	}
 
-	return db, nil
+	// Replicas lag behind, so that a job could not be found right after it
+	// was created; they are only used where it is chosen explicitly.
+	return UsePrimary(db), nil
+}
+
+// UsePrimary returns a session of db which never reads from replicas. It
+// must be used where data is read right after being written.
+func UsePrimary(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Write).Session(&gorm.Session{})
+}
+
+// UseReplicas returns a session of db which reads from the read replicas,
+// if there are any. It is only meant for reads which tolerate data lagging
+// behind, such as statistics.
+func UseReplicas(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Read).Session(&gorm.Session{})
 }
 
 func (d *Database) buildSQLiteDialector() gorm.Dialector {
@@ -55,19 +152,134 @@ func (d *Database) buildSQLiteDialector() gorm.Dialector {
 }
 
 func (d *Database) buildPostgreSQL() gorm.Dialector {
-	if d.PostgreSQL.Port == 0 {
-		d.PostgreSQL.Port = 5432
+	return postgres.Open(d.PostgreSQL.buildDSN())
+}
+
+func (p *PostgreSQL) buildDSN() string {
+	var params []string
+	if p.DSN == "" {
+		if p.Port == 0 {
+			p.Port = 5432
+		}
+
+		params = append(
+			params,
+			dsnParam("host", getOrDefault(p.Host, "localhost")),
+			dsnParam("user", getOrDefault(p.User, "postgres")),
+			dsnParam("password", p.Password),
+			dsnParam("dbname", p.DbName),
+			fmt.Sprintf("port=%d", p.Port),
+			dsnParam("sslmode", getOrDefault(p.SSLMode, "disable")),
+		)
+	} else if p.SSLMode != "" {
+		params = append(params, dsnParam("sslmode", p.SSLMode))
+	}
+
+	if p.SSLRootCert != "" {
+		params = append(params, dsnParam("sslrootcert", p.SSLRootCert))
+	}
+
+	if p.SSLCert != "" {
+		params = append(params, dsnParam("sslcert", p.SSLCert), dsnParam("sslkey", p.SSLKey))
+	}
+
+	if p.ConnectTimeoutSec > 0 {
+		params = append(params, fmt.Sprintf("connect_timeout=%d", p.ConnectTimeoutSec))
+	}
+
+	if p.StatementTimeoutMs > 0 {
+		params = append(params, fmt.Sprintf("statement_timeout=%d", p.StatementTimeoutMs))
 	}
 
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%d sslmode=disable",
-		getOrDefault(d.PostgreSQL.Host, "localhost"),
-		getOrDefault(d.PostgreSQL.User, "postgres"),
-		d.PostgreSQL.Password,
-		d.PostgreSQL.DbName,
-		d.PostgreSQL.Port,
-	)
-	return postgres.Open(dsn)
+	return appendDSNParams(p.DSN, params)
+}
+
+// configure registers read replicas and applies the pool settings to the
+// primary and replica connections.
+func (p *PostgreSQL) configure(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	sqlDB.SetMaxOpenConns(p.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(getIntOrDefault(p.MaxIdleConns, 2))
+	sqlDB.SetConnMaxLifetime(time.Duration(p.ConnMaxLifetimeSec) * time.Second)
+	sqlDB.SetConnMaxIdleTime(time.Duration(p.ConnMaxIdleTimeSec) * time.Second)
+	if len(p.ReadReplicas) == 0 {
+		return nil
+	}
+
+	replicas := make([]gorm.Dialector, len(p.ReadReplicas))
+	for i, dsn := range p.ReadReplicas {
+		replica := PostgreSQL{
+			DSN:                dsn,
+			SSLMode:            p.SSLMode,
+			SSLRootCert:        p.SSLRootCert,
+			SSLCert:            p.SSLCert,
+			SSLKey:             p.SSLKey,
+			ConnectTimeoutSec:  p.ConnectTimeoutSec,
+			StatementTimeoutMs: p.StatementTimeoutMs,
+		}
+		replicas[i] = postgres.Open(replica.buildDSN())
+	}
+
+	resolver := dbresolver.Register(dbresolver.Config{Replicas: replicas, Policy: dbresolver.RandomPolicy{}})
+	err = db.Use(resolver)
+	if err != nil {
+		return err
+	}
+
+	// Connection pools of the replicas exist only after the plugin is
+	// initialized.
+	resolver.
+		SetMaxOpenConns(p.MaxOpenConns).
+		SetMaxIdleConns(getIntOrDefault(p.MaxIdleConns, 2)).
+		SetConnMaxLifetime(time.Duration(p.ConnMaxLifetimeSec) * time.Second).
+		SetConnMaxIdleTime(time.Duration(p.ConnMaxIdleTimeSec) * time.Second)
+	return nil
+}
+
+// appendDSNParams adds keyword/value parameters to a DSN in either the
+// keyword/value or the URL format.
+func appendDSNParams(dsn string, params []string) string {
+	if len(params) == 0 {
+		return dsn
+	}
+
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return strings.TrimSpace(dsn + " " + strings.Join(params, " "))
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	for _, param := range params {
+		idx := strings.Index(param, "=")
+		dsn += separator + param[:idx] + "=" + url.QueryEscape(unquoteDSNValue(param[idx+1:]))
+		separator = "&"
+	}
+
+	return dsn
+}
+
+func dsnParam(key, value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return key + "=" + value
+	}
+
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return key + "='" + value + "'"
+}
+
+func unquoteDSNValue(value string) string {
+	if len(value) < 2 || value[0] != '\'' {
+		return value
+	}
+
+	return strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(value[1 : len(value)-1])
 }
 
 func getOrDefault(val, default_ string) string {
@@ -77,3 +289,21 @@ func getOrDefault(val, default_ string) string {
 
 	return val
 }
+
+func getIntOrDefault(val, default_ int) int {
+	if val == 0 {
+		return default_
+	}
+
+	return val
+}
+
+func stringArrayContains(array []string, item string) bool {
+	for _, elem := range array {
+		if elem == item {
+			return true
+		}
+	}
+
+	return false
+}
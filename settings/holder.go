@@ -0,0 +1,175 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"borsch-playground-api/logging"
+	"github.com/fsnotify/fsnotify"
+)
+
+const reloadDebounce = 200 * time.Millisecond
+
+// Holder keeps the current settings and atomically replaces their
+// reloadable part when the settings file changes or SIGHUP is received.
+type Holder struct {
+	filename  string
+	overrides []string
+	current   atomic.Value
+
+	mu        sync.Mutex
+	listeners []func(*Settings)
+}
+
+// NewHolder loads the settings the same way as Load does and remembers
+// where they came from to be able to reload them later.
+func NewHolder(filename string, overrides []string) (*Holder, error) {
+	s, err := Load(filename, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Holder{overrides: overrides}
+	h.filename, _ = resolveFilename(filename)
+	h.current.Store(s)
+	return h, nil
+}
+
+// Get returns the current settings. The returned value must not be modified.
+func (h *Holder) Get() *Settings {
+	return h.current.Load().(*Settings)
+}
+
+// OnReload registers fn to be called with the new settings after every
+// successful reload.
+func (h *Holder) OnReload(fn func(*Settings)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners = append(h.listeners, fn)
+}
+
+// Reload loads and validates the settings again. Invalid settings are
+// rejected and the current ones are kept. Only fields tagged with
+// `reload:"true"` are applied, changes of the others are logged.
+func (h *Holder) Reload() error {
+	next, err := Load(h.filename, h.overrides)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	merged := *h.Get()
+	restart := mergeReloadable(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(next).Elem())
+	if len(restart) > 0 {
+		logging.Warningf("settings reloaded, changes of '%s' require a restart", strings.Join(restart, "', '"))
+	}
+
+	h.current.Store(&merged)
+	for _, fn := range h.listeners {
+		fn(&merged)
+	}
+
+	return nil
+}
+
+// Watch reloads the settings on changes of the settings file and on
+// SIGHUP until ctx is done.
+func (h *Holder) Watch(ctx context.Context) error {
+	filename, err := filepath.Abs(h.filename)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// Editors often replace the file instead of writing to it, so the
+	// directory is watched rather than the file itself.
+	err = watcher.Add(filepath.Dir(filename))
+	if err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangup)
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				h.reloadAndLog("SIGHUP")
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if event.Name == filename && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					debounce = time.After(reloadDebounce)
+				}
+			case <-debounce:
+				debounce = nil
+				h.reloadAndLog("file change")
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				logging.Errorf("settings watcher: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (h *Holder) reloadAndLog(reason string) {
+	if err := h.Reload(); err != nil {
+		logging.Errorf("rejected reloaded settings (%s): %v", reason, err)
+		return
+	}
+
+	logging.Infof("settings reloaded (%s)", reason)
+}
+
+// mergeReloadable copies reloadable fields from src to dst and returns the
+// names of other fields which differ.
+func mergeReloadable(dst, src reflect.Value) []string {
+	var restart []string
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("reload") == "true" {
+			dst.Field(i).Set(src.Field(i))
+		} else if !reflect.DeepEqual(dst.Field(i).Interface(), src.Field(i).Interface()) {
+			restart = append(restart, jsonName(sf))
+		}
+	}
+
+	return restart
+}
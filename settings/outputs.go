@@ -0,0 +1,44 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+const (
+	OutputStorageRows   = "rows"
+	OutputStorageChunks = "chunks"
+)
+
+// Outputs selects how new outputs of jobs are stored: a row per line or
+// gzipped chunks of up to ChunkLines lines. Outputs stored either way stay
+// readable when Storage is changed.
+type Outputs struct {
+	Storage    string `json:"storage"`
+	ChunkLines int    `json:"chunk_lines"`
+}
+
+func (o *Outputs) Chunked() bool {
+	return o != nil && o.Storage == OutputStorageChunks
+}
+
+func (o *Outputs) validate(errs *ValidationErrors, field string) {
+	switch o.Storage {
+	case OutputStorageRows, OutputStorageChunks:
+		break
+	default:
+		errs.add(
+			field+".storage",
+			"invalid storage, available values are '%s', '%s'",
+			OutputStorageRows,
+			OutputStorageChunks,
+		)
+	}
+
+	if o.ChunkLines <= 0 {
+		errs.add(field+".chunk_lines", "must be positive")
+	}
+}
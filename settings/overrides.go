@@ -0,0 +1,244 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	EnvPrefix = "BORSCH_"
+
+	redactedValue = "********"
+)
+
+// lookupFunc returns the raw value of the setting at the given JSON path.
+type lookupFunc func(path []string) (string, bool)
+
+func envLookup(path []string) (string, bool) {
+	return os.LookupEnv(EnvName(path))
+}
+
+// EnvName returns the name of the environment variable which overrides
+// the setting at the given JSON path, e.g. BORSCH_DATABASE_POSTGRESQL_HOST.
+func EnvName(path []string) string {
+	return EnvPrefix + strings.ToUpper(strings.Join(path, "_"))
+}
+
+// applyOverrides sets every field of the struct v for which lookup returns
+// a value. Nil nested structs are allocated only when one of their fields
+// is overridden. Returns true if anything was changed.
+func applyOverrides(v reflect.Value, path []string, lookup lookupFunc) (bool, error) {
+	changed := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := jsonName(sf)
+		if name == "" {
+			continue
+		}
+
+		fieldPath := append(append([]string{}, path...), name)
+		field := v.Field(i)
+		if sf.Type.Kind() == reflect.Ptr && sf.Type.Elem().Kind() == reflect.Struct {
+			target := field
+			if field.IsNil() {
+				target = reflect.New(sf.Type.Elem())
+			}
+
+			ok, err := applyOverrides(target.Elem(), fieldPath, lookup)
+			if err != nil {
+				return false, err
+			}
+
+			if ok {
+				field.Set(target)
+				changed = true
+			}
+
+			continue
+		}
+
+		if sf.Type.Kind() == reflect.Struct {
+			ok, err := applyOverrides(field, fieldPath, lookup)
+			if err != nil {
+				return false, err
+			}
+
+			changed = changed || ok
+			continue
+		}
+
+		raw, ok := lookup(fieldPath)
+		if !ok {
+			continue
+		}
+
+		if err := setValue(field, raw); err != nil {
+			return false, fmt.Errorf("%s: %v", strings.Join(fieldPath, "."), err)
+		}
+
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// parseOverrides converts "key.path=value" strings to a lookup function
+// and reports keys which do not match any setting.
+func parseOverrides(overrides []string) (lookupFunc, error) {
+	values := map[string]string{}
+	for _, o := range overrides {
+		idx := strings.Index(o, "=")
+		if idx < 1 {
+			return nil, fmt.Errorf("invalid override %q, expected key=value", o)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(o[:idx]))
+		if !isKnownPath(reflect.TypeOf(Settings{}), strings.Split(key, ".")) {
+			return nil, fmt.Errorf("unknown setting: %s", key)
+		}
+
+		values[key] = o[idx+1:]
+	}
+
+	return func(path []string) (string, bool) {
+		value, ok := values[strings.Join(path, ".")]
+		return value, ok
+	}, nil
+}
+
+func isKnownPath(t reflect.Type, path []string) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if len(path) == 0 {
+		return t.Kind() != reflect.Struct
+	}
+
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == path[0] {
+			return isKnownPath(t.Field(i).Type, path[1:])
+		}
+	}
+
+	return false
+}
+
+func setValue(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type: %s", field.Type())
+		}
+
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type: %s", field.Type())
+	}
+
+	return nil
+}
+
+// redact replaces values of fields tagged with `secret:"true"`. Passwords
+// in URLs are masked while the rest of the URL is kept.
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		field := v.Field(i)
+		switch {
+		case sf.Type.Kind() == reflect.Ptr && sf.Type.Elem().Kind() == reflect.Struct:
+			if !field.IsNil() {
+				redact(field.Elem())
+			}
+		case sf.Type.Kind() == reflect.Struct:
+			redact(field)
+		case sf.Tag.Get("secret") == "true" && sf.Type.Kind() == reflect.String && field.String() != "":
+			field.SetString(redactString(field.String()))
+		case sf.Tag.Get("secret") == "true" && sf.Type.Kind() == reflect.Slice && sf.Type.Elem().Kind() == reflect.String:
+			for j := 0; j < field.Len(); j++ {
+				field.Index(j).SetString(redactString(field.Index(j).String()))
+			}
+		}
+	}
+}
+
+func redactString(value string) string {
+	u, err := url.Parse(value)
+	if err == nil && u.Scheme != "" && u.User != nil {
+		return u.Redacted()
+	}
+
+	return redactedValue
+}
+
+func jsonName(sf reflect.StructField) string {
+	if sf.PkgPath != "" {
+		return ""
+	}
+
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+
+	if name == "" {
+		name = strings.ToLower(sf.Name)
+	}
+
+	return name
+}
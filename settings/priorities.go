@@ -0,0 +1,49 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+import "fmt"
+
+// Priorities assigns priorities to jobs, higher ones are run first. Clients
+// are identified by IP address; those missing in Clients get Default. A
+// request may ask for a lower priority than its client has, but not for a
+// higher one.
+type Priorities struct {
+	Default int            `json:"default"`
+	Clients map[string]int `json:"clients"`
+}
+
+// Of returns the priority of the client.
+func (p *Priorities) Of(client string) int {
+	if p == nil {
+		return 0
+	}
+
+	if priority, ok := p.Clients[client]; ok {
+		return priority
+	}
+
+	return p.Default
+}
+
+func (p *Priorities) validate(errs *ValidationErrors, field string, maxPriority int) {
+	if p.Default < 0 || p.Default > maxPriority {
+		errs.add(field+".default", "must be between 0 and rabbitmq.max_priority (%d)", maxPriority)
+	}
+
+	for client, priority := range p.Clients {
+		if priority < 0 || priority > maxPriority {
+			errs.add(
+				fmt.Sprintf("%s.clients[%q]", field, client),
+				"must be between 0 and rabbitmq.max_priority (%d)",
+				maxPriority,
+			)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ParseTrustedProxies parses IP addresses and CIDR ranges of proxies whose
+// X-Forwarded-* headers are trusted.
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, len(proxies))
+	for i, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("'%s' is neither an IP address nor a CIDR range", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			networks[i] = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is neither an IP address nor a CIDR range", proxy)
+		}
+
+		networks[i] = network
+	}
+
+	return networks, nil
+}
+
+func validatePublicUrl(errs *ValidationErrors, field, value string) {
+	validateUrl(errs, field, value, "http", "https")
+	u, err := url.Parse(value)
+	if err == nil && (u.RawQuery != "" || u.Fragment != "" || u.User != nil) {
+		errs.add(field, "URL must not have user info, query or fragment")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+import "fmt"
+
+const (
+	// maxQueuePriority is the highest priority supported by RabbitMQ.
+	maxQueuePriority = 255
+
+	// maxPrefetchCount is the highest prefetch count of a RabbitMQ
+	// consumer.
+	maxPrefetchCount = 65535
+
+	maxResultConsumers = 64
+)
+
+// RabbitMQ describes where jobs are published. Without JobExchange all jobs
+// go to JobQueue; with it, jobs are routed through a direct exchange by
+// their language version to one of JobQueues, so that dedicated workers can
+// consume specific versions. MaxPriority enables priority queues; queues
+// which already exist must be deleted when it is changed. Job results are
+// processed by ResultConsumers workers, each storing log lines in batches of
+// up to ResultBatchSize lines which wait no longer than ResultFlushMs; at
+// most ResultPrefetch results are delivered before they are acknowledged.
+type RabbitMQ struct {
+	Server          string     `json:"server" secret:"true"`
+	JobQueue        string     `json:"job_queue"`
+	ResultQueue     string     `json:"result_queue"`
+	JobExchange     string     `json:"job_exchange"`
+	JobQueues       []JobQueue `json:"job_queues"`
+	MaxPriority     int        `json:"max_priority"`
+	ResultConsumers int        `json:"result_consumers"`
+	ResultPrefetch  int        `json:"result_prefetch"`
+	ResultBatchSize int        `json:"result_batch_size"`
+	ResultFlushMs   int        `json:"result_flush_ms"`
+}
+
+type JobQueue struct {
+	Name     string   `json:"name"`
+	Versions []string `json:"versions"`
+}
+
+func (r *RabbitMQ) validate(errs *ValidationErrors, field string) {
+	if r.Server == "" {
+		errs.add(field+".server", "server URL is required")
+	} else {
+		validateUrl(errs, field+".server", r.Server, "amqp", "amqps")
+	}
+
+	if r.JobExchange == "" {
+		validateQueueName(errs, field+".job_queue", r.JobQueue)
+		if len(r.JobQueues) > 0 {
+			errs.add(field+".job_queues", "job queues require a job exchange")
+		}
+	} else {
+		r.validateJobQueues(errs, field)
+	}
+
+	validateQueueName(errs, field+".result_queue", r.ResultQueue)
+	if r.JobQueue != "" && r.JobQueue == r.ResultQueue {
+		errs.add(field+".result_queue", "must differ from the job queue")
+	}
+
+	if r.MaxPriority < 0 || r.MaxPriority > maxQueuePriority {
+		errs.add(field+".max_priority", "must be between 0 and %d", maxQueuePriority)
+	}
+
+	if r.ResultConsumers <= 0 || r.ResultConsumers > maxResultConsumers {
+		errs.add(field+".result_consumers", "must be between 1 and %d", maxResultConsumers)
+	}
+
+	if r.ResultPrefetch <= 0 || r.ResultPrefetch > maxPrefetchCount {
+		errs.add(field+".result_prefetch", "must be between 1 and %d", maxPrefetchCount)
+	}
+
+	if r.ResultBatchSize <= 0 {
+		errs.add(field+".result_batch_size", "must be positive")
+	} else if r.ResultPrefetch > 0 && r.ResultBatchSize > r.ResultPrefetch {
+		// Batches could never fill up and would wait for the flush.
+		errs.add(field+".result_batch_size", "must not exceed the result prefetch")
+	}
+
+	if r.ResultFlushMs <= 0 {
+		errs.add(field+".result_flush_ms", "must be positive")
+	}
+}
+
+func (r *RabbitMQ) validateJobQueues(errs *ValidationErrors, field string) {
+	if len(r.JobExchange) > 255 {
+		errs.add(field+".job_exchange", "exchange name is longer than 255 bytes")
+	}
+
+	if len(r.JobQueues) == 0 {
+		errs.add(field+".job_queues", "at least one queue is required with a job exchange")
+	}
+
+	names := map[string]bool{}
+	versions := map[string]bool{}
+	for i, queue := range r.JobQueues {
+		queueField := fmt.Sprintf("%s.job_queues[%d]", field, i)
+		validateQueueName(errs, queueField+".name", queue.Name)
+		if names[queue.Name] {
+			errs.add(queueField+".name", "duplicate queue '%s'", queue.Name)
+		} else if queue.Name == r.ResultQueue {
+			errs.add(queueField+".name", "must differ from the result queue")
+		}
+
+		names[queue.Name] = true
+		if len(queue.Versions) == 0 {
+			errs.add(queueField+".versions", "at least one version is required")
+		}
+
+		for j, version := range queue.Versions {
+			if versions[version] {
+				// A version bound to several queues would be run several
+				// times.
+				errs.add(fmt.Sprintf("%s.versions[%d]", queueField, j), "version '%s' is already routed", version)
+			}
+
+			versions[version] = true
+		}
+	}
+}
+
+// routes reports whether jobs of the version reach a queue.
+func (r *RabbitMQ) routes(version string) bool {
+	if r.JobExchange == "" {
+		return true
+	}
+
+	for _, queue := range r.JobQueues {
+		if stringArrayContains(queue.Versions, version) {
+			return true
+		}
+	}
+
+	return false
+}
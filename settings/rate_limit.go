@@ -0,0 +1,30 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+// RateLimit limits the number of jobs a single client can create.
+// Zero JobsPerMinute disables the limit.
+type RateLimit struct {
+	JobsPerMinute float64 `json:"jobs_per_minute"`
+	Burst         int     `json:"burst"`
+}
+
+func (r *RateLimit) Enabled() bool {
+	return r != nil && r.JobsPerMinute > 0
+}
+
+func (r *RateLimit) validate(errs *ValidationErrors, field string) {
+	if r.JobsPerMinute < 0 {
+		errs.add(field+".jobs_per_minute", "must not be negative")
+	}
+
+	if r.JobsPerMinute > 0 && r.Burst < 1 {
+		errs.add(field+".burst", "must be positive when the rate limit is enabled")
+	}
+}
@@ -0,0 +1,37 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+// ResultCache configures reuse of results of identical programs. Zero
+// TtlSec disables the cache. Results with more than MaxOutputBytes of
+// output are not cached; when there are more than MaxEntries results, the
+// ones expiring first are dropped.
+type ResultCache struct {
+	TtlSec         int `json:"ttl_sec"`
+	MaxEntries     int `json:"max_entries"`
+	MaxOutputBytes int `json:"max_output_bytes"`
+}
+
+func (r *ResultCache) Enabled() bool {
+	return r != nil && r.TtlSec > 0
+}
+
+func (r *ResultCache) validate(errs *ValidationErrors, field string) {
+	if r.TtlSec < 0 {
+		errs.add(field+".ttl_sec", "must not be negative")
+	}
+
+	if r.TtlSec > 0 && r.MaxEntries <= 0 {
+		errs.add(field+".max_entries", "must be positive when the cache is enabled")
+	}
+
+	if r.TtlSec > 0 && r.MaxOutputBytes <= 0 {
+		errs.add(field+".max_output_bytes", "must be positive when the cache is enabled")
+	}
+}
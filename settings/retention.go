@@ -0,0 +1,48 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+import "fmt"
+
+// Retention configures removal of old jobs. Jobs in one of Statuses are
+// removed when they are older than MaxAgeHours or when their client has
+// more than MaxJobsPerClient newer jobs; zero values disable the rules.
+type Retention struct {
+	MaxAgeHours      int      `json:"max_age_hours"`
+	MaxJobsPerClient int      `json:"max_jobs_per_client"`
+	Statuses         []string `json:"statuses"`
+	IntervalSec      int      `json:"interval_sec"`
+	BatchSize        int      `json:"batch_size"`
+}
+
+var retentionStatuses = []string{"accepted", "rejected", "queued", "running", "finished"}
+
+func (r *Retention) validate(errs *ValidationErrors, field string) {
+	if r.MaxAgeHours < 0 {
+		errs.add(field+".max_age_hours", "must not be negative")
+	}
+
+	if r.MaxJobsPerClient < 0 {
+		errs.add(field+".max_jobs_per_client", "must not be negative")
+	}
+
+	for i, status := range r.Statuses {
+		if !stringArrayContains(retentionStatuses, status) {
+			errs.add(fmt.Sprintf("%s.statuses[%d]", field, i), "unknown job status '%s'", status)
+		}
+	}
+
+	if r.IntervalSec <= 0 {
+		errs.add(field+".interval_sec", "must be positive")
+	}
+
+	if r.BatchSize <= 0 {
+		errs.add(field+".batch_size", "must be positive")
+	}
+}
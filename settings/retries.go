@@ -0,0 +1,43 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+// Retries configures automatic retries of jobs which could not be published
+// or failed because of a worker error. A job is published at most
+// MaxAttempts times, one disables retries; the delay doubles after every
+// attempt starting from InitialBackoffSec up to MaxBackoffSec.
+type Retries struct {
+	MaxAttempts       int `json:"max_attempts"`
+	InitialBackoffSec int `json:"initial_backoff_sec"`
+	MaxBackoffSec     int `json:"max_backoff_sec"`
+	IntervalSec       int `json:"interval_sec"`
+	BatchSize         int `json:"batch_size"`
+}
+
+func (r *Retries) validate(errs *ValidationErrors, field string) {
+	positive := []struct {
+		name  string
+		value int
+	}{
+		{"max_attempts", r.MaxAttempts},
+		{"initial_backoff_sec", r.InitialBackoffSec},
+		{"max_backoff_sec", r.MaxBackoffSec},
+		{"interval_sec", r.IntervalSec},
+		{"batch_size", r.BatchSize},
+	}
+	for _, p := range positive {
+		if p.value <= 0 {
+			errs.add(field+"."+p.name, "must be positive")
+		}
+	}
+
+	if r.MaxBackoffSec > 0 && r.MaxBackoffSec < r.InitialBackoffSec {
+		errs.add(field+".max_backoff_sec", "must not be less than initial_backoff_sec")
+	}
+}
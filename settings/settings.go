@@ -10,29 +10,124 @@ package settings
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
 	"time"
 
+	"borsch-playground-api/logging"
 	"github.com/gin-gonic/gin"
 )
 
+// Settings fields tagged with `reload:"true"` are swapped at runtime when
+// the settings file changes, others require a restart. TrustedProxies are
+// not reloaded, since they also configure the client IP of the router,
+// which cannot be changed while requests are served.
 type Settings struct {
 	GinMode             string        `json:"gin_mode"`
 	ShutdownTimeoutSec  time.Duration `json:"shutdown_timeout_sec"`
-	BorschVersions      []string      `json:"borsch_versions"`
-	ApiDocumentationUrl string        `json:"api_documentation_url"`
+	LogLevel            string        `json:"log_level" reload:"true"`
+	BorschVersions      []string      `json:"borsch_versions" reload:"true"`
+	ApiDocumentationUrl string        `json:"api_documentation_url" reload:"true"`
+	PublicUrl           string        `json:"public_url" reload:"true"`
+	TrustedProxies      []string      `json:"trusted_proxies"`
+	RateLimit           *RateLimit    `json:"rate_limit" reload:"true"`
+	MaxBatchSize        int           `json:"max_batch_size" reload:"true"`
+	IdempotencyTtlHours int           `json:"idempotency_ttl_hours" reload:"true"`
+	Retention           *Retention    `json:"retention" reload:"true"`
+	Webhooks            *Webhooks     `json:"webhooks" reload:"true"`
+	Retries             *Retries      `json:"retries" reload:"true"`
+	ResultCache         *ResultCache  `json:"result_cache" reload:"true"`
+	Priorities          *Priorities   `json:"priorities" reload:"true"`
+	Admin               *Admin        `json:"admin" reload:"true"`
+	Outputs             *Outputs      `json:"outputs"`
+	Blobs               *Blobs        `json:"blobs"`
 	Database            *Database     `json:"database"`
+	RabbitMQ            *RabbitMQ     `json:"rabbitmq"`
 }
 
+const (
+	EnvConfig = EnvPrefix + "CONFIG"
+
+	defaultFilename = "settings.json"
+)
+
+// Default returns the settings used for the values which are not set in
+// the settings file, environment or flags.
+func Default() *Settings {
+	return &Settings{
+		GinMode:             gin.DebugMode,
+		ShutdownTimeoutSec:  5,
+		LogLevel:            logging.LevelInfo.String(),
+		MaxBatchSize:        100,
+		IdempotencyTtlHours: 24,
+		Retention: &Retention{
+			Statuses:    []string{"rejected", "finished"},
+			IntervalSec: 3600,
+			BatchSize:   500,
+		},
+		Webhooks: &Webhooks{
+			MaxAttempts:       5,
+			InitialBackoffSec: 10,
+			MaxBackoffSec:     3600,
+			TimeoutSec:        10,
+			IntervalSec:       5,
+			BatchSize:         50,
+		},
+		Retries: &Retries{
+			MaxAttempts:       5,
+			InitialBackoffSec: 5,
+			MaxBackoffSec:     300,
+			IntervalSec:       5,
+			BatchSize:         50,
+		},
+		ResultCache: &ResultCache{
+			MaxEntries:     10000,
+			MaxOutputBytes: 64 << 10,
+		},
+		Outputs: &Outputs{
+			Storage:    OutputStorageRows,
+			ChunkLines: 1000,
+		},
+		Database: &Database{},
+		RabbitMQ: &RabbitMQ{
+			ResultConsumers: 4,
+			ResultPrefetch:  1000,
+			ResultBatchSize: 200,
+			ResultFlushMs:   100,
+		},
+	}
+}
+
+// Redacted returns a copy of the settings with secrets masked.
+func (s *Settings) Redacted() *Settings {
+	bytes, err := json.Marshal(s)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	c := &Settings{}
+	if err = json.Unmarshal(bytes, c); err != nil {
+		log.Fatalln(err)
+	}
+
+	redact(reflect.ValueOf(c).Elem())
+	return c
+}
+
+// PerformChecks validates the settings and reports all problems at once
+// as ValidationErrors.
 func (s *Settings) PerformChecks() error {
+	var errs ValidationErrors
 	switch s.GinMode {
 	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
 		break
 	default:
-		return fmt.Errorf(
+		errs.add(
+			"gin_mode",
 			"invalid Gin mode, available values are '%s', '%s', '%s'",
 			gin.DebugMode,
 			gin.ReleaseMode,
@@ -40,17 +135,166 @@ func (s *Settings) PerformChecks() error {
 		)
 	}
 
+	if s.ShutdownTimeoutSec <= 0 {
+		errs.add("shutdown_timeout_sec", "must be positive")
+	}
+
+	if _, err := logging.ParseLevel(s.LogLevel); err != nil {
+		errs.add("log_level", err.Error())
+	}
+
+	if s.MaxBatchSize <= 0 {
+		errs.add("max_batch_size", "must be positive")
+	}
+
+	if s.IdempotencyTtlHours <= 0 {
+		errs.add("idempotency_ttl_hours", "must be positive")
+	}
+
+	if s.RateLimit != nil {
+		s.RateLimit.validate(&errs, "rate_limit")
+	}
+
+	if len(s.BorschVersions) == 0 {
+		errs.add("borsch_versions", "at least one version is required")
+	}
+
+	seen := map[string]bool{}
+	for i, version := range s.BorschVersions {
+		field := fmt.Sprintf("borsch_versions[%d]", i)
+		if !IsSemVer(version) {
+			errs.add(field, "'%s' is not a valid SemVer", version)
+		} else if seen[version] {
+			errs.add(field, "duplicate version '%s'", version)
+		}
+
+		seen[version] = true
+	}
+
+	if s.ApiDocumentationUrl != "" {
+		validateUrl(&errs, "api_documentation_url", s.ApiDocumentationUrl, "http", "https")
+	}
+
+	if s.PublicUrl != "" {
+		validatePublicUrl(&errs, "public_url", s.PublicUrl)
+	}
+
+	for i, proxy := range s.TrustedProxies {
+		if _, err := ParseTrustedProxies([]string{proxy}); err != nil {
+			errs.add(fmt.Sprintf("trusted_proxies[%d]", i), err.Error())
+		}
+	}
+
+	if s.Retention == nil {
+		errs.add("retention", "retention is not set")
+	} else {
+		s.Retention.validate(&errs, "retention")
+	}
+
+	if s.Webhooks == nil {
+		errs.add("webhooks", "webhooks are not set")
+	} else {
+		s.Webhooks.validate(&errs, "webhooks")
+	}
+
+	if s.Retries == nil {
+		errs.add("retries", "retries are not set")
+	} else {
+		s.Retries.validate(&errs, "retries")
+	}
+
+	if s.ResultCache != nil {
+		s.ResultCache.validate(&errs, "result_cache")
+	}
+
+	if s.Admin != nil {
+		s.Admin.validate(&errs, "admin")
+	}
+
+	if s.Outputs == nil {
+		errs.add("outputs", "outputs are not set")
+	} else {
+		s.Outputs.validate(&errs, "outputs")
+	}
+
+	if s.Blobs != nil {
+		s.Blobs.validate(&errs, "blobs")
+	}
+
+	if s.Database == nil {
+		errs.add("database", "database is not set")
+	} else {
+		s.Database.validate(&errs, "database")
+	}
+
+	if s.RabbitMQ == nil {
+		errs.add("rabbitmq", "RabbitMQ is not set")
+	} else {
+		s.RabbitMQ.validate(&errs, "rabbitmq")
+		for i, version := range s.BorschVersions {
+			if !s.RabbitMQ.routes(version) {
+				errs.add(fmt.Sprintf("borsch_versions[%d]", i), "no job queue is bound to version '%s'", version)
+			}
+		}
+
+		if s.Priorities != nil {
+			s.Priorities.validate(&errs, "priorities", s.RabbitMQ.MaxPriority)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
 	return nil
 }
 
-func Load() (*Settings, error) {
-	return load("settings.json")
+// Load builds the settings in layers: defaults, the settings file, BORSCH_*
+// environment variables and finally "key.path=value" overrides from flags.
+// If filename is empty, BORSCH_CONFIG is used, then "settings.json" which,
+// unlike an explicitly given file, may be missing.
+func Load(filename string, overrides []string) (*Settings, error) {
+	s := Default()
+	filename, required := resolveFilename(filename)
+	err := load(filename, s)
+	if err != nil && (required || !errors.Is(err, os.ErrNotExist)) {
+		return nil, err
+	}
+
+	_, err = applyOverrides(reflect.ValueOf(s).Elem(), nil, envLookup)
+	if err != nil {
+		return nil, fmt.Errorf("environment: %v", err)
+	}
+
+	flagLookup, err := parseOverrides(overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = applyOverrides(reflect.ValueOf(s).Elem(), nil, flagLookup)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, s.PerformChecks()
+}
+
+func resolveFilename(filename string) (string, bool) {
+	if filename == "" {
+		filename = os.Getenv(EnvConfig)
+	}
+
+	if filename == "" {
+		return defaultFilename, false
+	}
+
+	return filename, true
 }
 
-func load(filename string) (*Settings, error) {
+func load(filename string, s *Settings) error {
 	jsonFile, err := os.Open(filename)
 	if err != nil {
-		return nil, fmt.Errorf("%v: %s", err, filename)
+		return fmt.Errorf("%w: %s", err, filename)
 	}
 
 	defer func() {
@@ -62,14 +306,8 @@ func load(filename string) (*Settings, error) {
 
 	bytes, err := ioutil.ReadAll(jsonFile)
 	if err != nil {
-		return nil, err
-	}
-
-	var s Settings
-	err = json.Unmarshal(bytes, &s)
-	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &s, nil
+	return json.Unmarshal(bytes, s)
 }
@@ -0,0 +1,82 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var semVerRegex = regexp.MustCompile(
+	`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+		`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+		`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`,
+)
+
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors holds every problem found in the settings.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = "  " + err.Error()
+	}
+
+	return "invalid settings:\n" + strings.Join(lines, "\n")
+}
+
+func (e *ValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func IsSemVer(version string) bool {
+	return semVerRegex.MatchString(version)
+}
+
+func validateUrl(errs *ValidationErrors, field, value string, schemes ...string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		errs.add(field, "invalid URL")
+		return
+	}
+
+	if u.Host == "" {
+		errs.add(field, "URL must be absolute")
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+
+	errs.add(field, "URL scheme must be one of '%s'", strings.Join(schemes, "', '"))
+}
+
+func validateQueueName(errs *ValidationErrors, field, name string) {
+	switch {
+	case name == "":
+		errs.add(field, "queue name is required")
+	case len(name) > 255:
+		errs.add(field, "queue name is longer than 255 bytes")
+	case strings.HasPrefix(name, "amq."):
+		errs.add(field, "queue names starting with 'amq.' are reserved by the broker")
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * Borsch Playground API
+ *
+ * Copyright (C) 2022 Yuriy Lisovskiy - All Rights Reserved
+ * You may use, distribute and modify this code under the
+ * terms of the MIT license.
+ */
+
+package settings
+
+// Webhooks configures callbacks sent when jobs finish. Payloads are signed
+// with Secret, an empty secret disables callbacks. Failed deliveries are
+// retried up to MaxAttempts times, the delay doubles after every attempt
+// starting from InitialBackoffSec up to MaxBackoffSec.
+type Webhooks struct {
+	Secret            string `json:"secret" secret:"true"`
+	MaxAttempts       int    `json:"max_attempts"`
+	InitialBackoffSec int    `json:"initial_backoff_sec"`
+	MaxBackoffSec     int    `json:"max_backoff_sec"`
+	TimeoutSec        int    `json:"timeout_sec"`
+	IntervalSec       int    `json:"interval_sec"`
+	BatchSize         int    `json:"batch_size"`
+}
+
+func (w *Webhooks) Enabled() bool {
+	return w != nil && w.Secret != ""
+}
+
+func (w *Webhooks) validate(errs *ValidationErrors, field string) {
+	positive := []struct {
+		name  string
+		value int
+	}{
+		{"max_attempts", w.MaxAttempts},
+		{"initial_backoff_sec", w.InitialBackoffSec},
+		{"max_backoff_sec", w.MaxBackoffSec},
+		{"timeout_sec", w.TimeoutSec},
+		{"interval_sec", w.IntervalSec},
+		{"batch_size", w.BatchSize},
+	}
+	for _, p := range positive {
+		if p.value <= 0 {
+			errs.add(field+"."+p.name, "must be positive")
+		}
+	}
+
+	if w.MaxBackoffSec > 0 && w.MaxBackoffSec < w.InitialBackoffSec {
+		errs.add(field+".max_backoff_sec", "must not be less than initial_backoff_sec")
+	}
+}